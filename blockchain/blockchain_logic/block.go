@@ -2,79 +2,122 @@
 package blockchain_logic
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
-	"strings"
 	"time"
 )
 
+// BlockHeader holds the block metadata. The block hash covers only these
+// fields; the transactions are committed through MerkleRoot.
+type BlockHeader struct {
+	Index      int64  `json:"index"`
+	Timestamp  int64  `json:"timestamp"`
+	MerkleRoot string `json:"merkle_root"`
+	PrevHash   string `json:"prev_hash"`
+	Hash       string `json:"hash"`
+	Nonce      int64  `json:"nonce"`
+	Difficulty uint64 `json:"difficulty"` // Target is maxTarget / Difficulty
+	// The transaction validator every node must run on this block's
+	// transactions: the inference rules version and the model hash
+	ModelVersion int    `json:"model_version"`
+	ModelHash    string `json:"model_hash"`
+}
+
 type Block struct {
-	Index        int64         `json:"index"`
-	Timestamp    int64         `json:"timestamp"`
+	BlockHeader
 	Transactions []Transaction `json:"transactions"`
-	PrevHash     string        `json:"prev_hash"`
-	Hash         string        `json:"hash"`
-	Nonce        int64         `json:"nonce"`
-	Difficulty   int           `json:"difficulty"`
 }
 
-// CreateBlock creates a new block with the given transactions
-func CreateBlock(index int64, transactions []Transaction, prevHash string, difficulty int) *Block {
+// GenesisTimestamp is fixed so that every node mines the same genesis block
+const GenesisTimestamp int64 = 1704067200
+
+// newBlock builds an unmined block committing to the given transactions and
+// validator model
+func newBlock(index, timestamp int64, transactions []Transaction, prevHash string, difficulty uint64, modelHash string) *Block {
 	block := &Block{
-		Index:        index,
-		Timestamp:    time.Now().Unix(),
+		BlockHeader: BlockHeader{
+			Index:        index,
+			Timestamp:    timestamp,
+			PrevHash:     prevHash,
+			Difficulty:   difficulty,
+			Nonce:        0,
+			ModelVersion: ValidatorVersion,
+			ModelHash:    modelHash,
+		},
 		Transactions: transactions,
-		PrevHash:     prevHash,
-		Difficulty:   difficulty,
-		Nonce:        0,
 	}
-	block.Mine()
+	block.MerkleRoot = ComputeMerkleRoot(block.TransactionHashes())
 	return block
 }
 
-// CalculateHash calculates the hash of the block
-func (b *Block) CalculateHash() string {
+// CreateGenesisBlock creates the deterministic first block of the chain
+// holding the genesis allocation transactions and committing to the
+// validator model of the chain
+func CreateGenesisBlock(difficulty uint64, allocations []Transaction, modelHash string) *Block {
+	block := newBlock(0, GenesisTimestamp, allocations, "", difficulty, modelHash)
+	// A single worker always finds the lowest valid nonce
+	NewMiner(1).Mine(context.Background(), block)
+	return block
+}
+
+// CreateBlock creates and mines a new block with the given transactions using
+// all CPUs. It returns early with the context error if ctx is cancelled.
+func CreateBlock(ctx context.Context, index int64, transactions []Transaction, prevHash string, difficulty uint64, modelHash string) (*Block, error) {
+	block := newBlock(index, time.Now().Unix(), transactions, prevHash, difficulty, modelHash)
+	if err := NewMiner(0).Mine(ctx, block); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// CalculateHash calculates the hash of the block header
+func (h *BlockHeader) CalculateHash() string {
 	data, _ := json.Marshal(struct {
-		Index        int64         `json:"index"`
-		Timestamp    int64         `json:"timestamp"`
-		Transactions []Transaction `json:"transactions"`
-		PrevHash     string        `json:"prev_hash"`
-		Nonce        int64         `json:"nonce"`
+		Index        int64  `json:"index"`
+		Timestamp    int64  `json:"timestamp"`
+		MerkleRoot   string `json:"merkle_root"`
+		PrevHash     string `json:"prev_hash"`
+		Nonce        int64  `json:"nonce"`
+		Difficulty   uint64 `json:"difficulty"`
+		ModelVersion int    `json:"model_version"`
+		ModelHash    string `json:"model_hash"`
 	}{
-		Index:        b.Index,
-		Timestamp:    b.Timestamp,
-		Transactions: b.Transactions,
-		PrevHash:     b.PrevHash,
-		Nonce:        b.Nonce,
+		Index:        h.Index,
+		Timestamp:    h.Timestamp,
+		MerkleRoot:   h.MerkleRoot,
+		PrevHash:     h.PrevHash,
+		Nonce:        h.Nonce,
+		Difficulty:   h.Difficulty,
+		ModelVersion: h.ModelVersion,
+		ModelHash:    h.ModelHash,
 	})
 
 	hash := sha256.Sum256(data)
 	return hex.EncodeToString(hash[:])
 }
 
-// Mine performs the proof of work algorithm on the block
-func (b *Block) Mine() {
-	target := strings.Repeat("0", b.Difficulty)
-
-	for {
-		b.Hash = b.CalculateHash()
-		if strings.HasPrefix(b.Hash, target) {
-			fmt.Printf("Block mined! Hash: %s\n", b.Hash)
-			return
-		}
-		b.Nonce++
+// TransactionHashes returns the hashes of the block's transactions in order
+func (b *Block) TransactionHashes() []string {
+	hashes := make([]string, len(b.Transactions))
+	for i := range b.Transactions {
+		hashes[i] = b.Transactions[i].Hash()
 	}
+	return hashes
 }
 
-// ValidateBlock validates the block's hash and proof of work
+// ValidateBlock validates the block's hash, proof of work and that its
+// transactions match the Merkle root in the header
 func (b *Block) ValidateBlock() bool {
+	if ComputeMerkleRoot(b.TransactionHashes()) != b.MerkleRoot {
+		return false
+	}
+
 	calculatedHash := b.CalculateHash()
 	if calculatedHash != b.Hash {
 		return false
 	}
 
-	target := strings.Repeat("0", b.Difficulty)
-	return strings.HasPrefix(b.Hash, target)
+	return meetsTarget(b.Hash, b.Target())
 }
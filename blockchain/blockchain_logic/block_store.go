@@ -0,0 +1,286 @@
+// block_store.go
+package blockchain_logic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BlockStore is the content-addressed storage used by the blockchain for
+// individual blocks and full chain snapshots
+type BlockStore interface {
+	StoreBlock(block *Block) (string, error)
+	RetrieveBlock(hash string) (*Block, error)
+	StoreBlockchain(blocks []*Block) (string, error)
+	RetrieveBlockchain(hash string) ([]*Block, error)
+	Pin(hash string) error
+	Unpin(hash string) error
+}
+
+// Supported block store backends
+const (
+	BlockStoreIPFS       = "ipfs"
+	BlockStoreFilesystem = "fs"
+	BlockStoreMemory     = "memory"
+)
+
+// NewBlockStore creates a block store for the given backend. The location is
+// the IPFS API address for "ipfs" and the data directory for "fs"; it is
+// ignored for "memory". An empty location selects the backend's default.
+func NewBlockStore(backend, location string) (BlockStore, error) {
+	switch backend {
+	case BlockStoreIPFS:
+		if location == "" {
+			location = "localhost:5001"
+		}
+		return NewIPFSHandler(location)
+	case BlockStoreFilesystem:
+		if location == "" {
+			location = "blockstore"
+		}
+		return NewFileBlockStore(location)
+	case BlockStoreMemory:
+		return NewMemoryBlockStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown block store backend: %s", backend)
+	}
+}
+
+// contentHash returns the content address used by the local backends
+func contentHash(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// MemoryBlockStore keeps all content in memory
+type MemoryBlockStore struct {
+	objects map[string][]byte
+	pins    map[string]bool
+	mutex   sync.RWMutex
+}
+
+// NewMemoryBlockStore creates an empty in-memory block store
+func NewMemoryBlockStore() *MemoryBlockStore {
+	return &MemoryBlockStore{
+		objects: make(map[string][]byte),
+		pins:    make(map[string]bool),
+	}
+}
+
+func (ms *MemoryBlockStore) put(data []byte) string {
+	hash := contentHash(data)
+
+	ms.mutex.Lock()
+	ms.objects[hash] = data
+	ms.mutex.Unlock()
+
+	return hash
+}
+
+func (ms *MemoryBlockStore) get(hash string) ([]byte, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	data, exists := ms.objects[hash]
+	if !exists {
+		return nil, fmt.Errorf("content %s not found", hash)
+	}
+	return data, nil
+}
+
+// StoreBlock stores a block and returns its content hash
+func (ms *MemoryBlockStore) StoreBlock(block *Block) (string, error) {
+	blockData, err := json.Marshal(block)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal block: %v", err)
+	}
+	return ms.put(blockData), nil
+}
+
+// RetrieveBlock retrieves a block by its content hash
+func (ms *MemoryBlockStore) RetrieveBlock(hash string) (*Block, error) {
+	data, err := ms.get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve block: %v", err)
+	}
+
+	var block Block
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal block: %v", err)
+	}
+	return &block, nil
+}
+
+// StoreBlockchain stores a snapshot of the given blocks
+func (ms *MemoryBlockStore) StoreBlockchain(blocks []*Block) (string, error) {
+	blockchainData, err := json.Marshal(blocks)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal blockchain: %v", err)
+	}
+	return ms.put(blockchainData), nil
+}
+
+// RetrieveBlockchain retrieves a snapshot by its content hash
+func (ms *MemoryBlockStore) RetrieveBlockchain(hash string) ([]*Block, error) {
+	data, err := ms.get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve blockchain: %v", err)
+	}
+
+	var blocks []*Block
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal blockchain: %v", err)
+	}
+	return blocks, nil
+}
+
+// Pin marks content as pinned
+func (ms *MemoryBlockStore) Pin(hash string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if _, exists := ms.objects[hash]; !exists {
+		return fmt.Errorf("cannot pin unknown content %s", hash)
+	}
+	ms.pins[hash] = true
+	return nil
+}
+
+// Unpin removes the pin from content
+func (ms *MemoryBlockStore) Unpin(hash string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if !ms.pins[hash] {
+		return fmt.Errorf("content %s is not pinned", hash)
+	}
+	delete(ms.pins, hash)
+	return nil
+}
+
+// FileBlockStore keeps content in a local directory, one file per content
+// hash under objects/ and an empty marker file per pin under pins/
+type FileBlockStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileBlockStore creates a filesystem block store rooted at dir
+func NewFileBlockStore(dir string) (*FileBlockStore, error) {
+	for _, sub := range []string{"objects", "pins"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create block store directory: %v", err)
+		}
+	}
+	return &FileBlockStore{dir: dir}, nil
+}
+
+func (fs *FileBlockStore) objectPath(hash string) string {
+	return filepath.Join(fs.dir, "objects", hash)
+}
+
+func (fs *FileBlockStore) pinPath(hash string) string {
+	return filepath.Join(fs.dir, "pins", hash)
+}
+
+func (fs *FileBlockStore) put(data []byte) (string, error) {
+	hash := contentHash(data)
+	path := fs.objectPath(hash)
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return hash, nil // Content already stored
+	}
+
+	// Write to a temporary file first so readers never see partial content
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write content: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("failed to write content: %v", err)
+	}
+	return hash, nil
+}
+
+func (fs *FileBlockStore) get(hash string) ([]byte, error) {
+	hash = filepath.Base(hash)
+	data, err := os.ReadFile(fs.objectPath(hash))
+	if err != nil {
+		return nil, fmt.Errorf("content %s not found: %v", hash, err)
+	}
+	if contentHash(data) != hash {
+		return nil, fmt.Errorf("content %s is corrupted", hash)
+	}
+	return data, nil
+}
+
+// StoreBlock stores a block and returns its content hash
+func (fs *FileBlockStore) StoreBlock(block *Block) (string, error) {
+	blockData, err := json.Marshal(block)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal block: %v", err)
+	}
+	return fs.put(blockData)
+}
+
+// RetrieveBlock retrieves a block by its content hash
+func (fs *FileBlockStore) RetrieveBlock(hash string) (*Block, error) {
+	data, err := fs.get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve block: %v", err)
+	}
+
+	var block Block
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal block: %v", err)
+	}
+	return &block, nil
+}
+
+// StoreBlockchain stores a snapshot of the given blocks
+func (fs *FileBlockStore) StoreBlockchain(blocks []*Block) (string, error) {
+	blockchainData, err := json.Marshal(blocks)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal blockchain: %v", err)
+	}
+	return fs.put(blockchainData)
+}
+
+// RetrieveBlockchain retrieves a snapshot by its content hash
+func (fs *FileBlockStore) RetrieveBlockchain(hash string) ([]*Block, error) {
+	data, err := fs.get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve blockchain: %v", err)
+	}
+
+	var blocks []*Block
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal blockchain: %v", err)
+	}
+	return blocks, nil
+}
+
+// Pin marks content as pinned
+func (fs *FileBlockStore) Pin(hash string) error {
+	hash = filepath.Base(hash)
+	if _, err := os.Stat(fs.objectPath(hash)); err != nil {
+		return fmt.Errorf("cannot pin unknown content %s", hash)
+	}
+	return os.WriteFile(fs.pinPath(hash), nil, 0644)
+}
+
+// Unpin removes the pin from content
+func (fs *FileBlockStore) Unpin(hash string) error {
+	if err := os.Remove(fs.pinPath(filepath.Base(hash))); err != nil {
+		return fmt.Errorf("content %s is not pinned", hash)
+	}
+	return nil
+}
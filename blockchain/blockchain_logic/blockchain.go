@@ -2,98 +2,572 @@
 package blockchain_logic
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Blockchain struct
 type Blockchain struct {
-	Blocks      []*Block
-	mutex       sync.RWMutex
-	Difficulty  int
-	MLValidator *MLTransactionValidator
-	ipfsHandler *IPFSHandler // Added IPFS handler
+	Blocks         []*Block // Main chain, from the genesis block to the tip
+	mutex          sync.RWMutex
+	consensus      ConsensusParams
+	MLValidator    TransactionValidator  // Validator model the next block commits to
+	modelHash      string                // Hash of MLValidator
+	models         *modelHistory         // Validator models committed along the main chain
+	Mempool        *TransactionPool      // Pending transactions for the next blocks
+	Drift          *DriftMonitor         // Drift of live transactions from the training data, nil if disabled
+	velocity       *VelocityConfig       // Velocity checks on validated transactions, nil if disabled
+	store          BlockStore            // Storage backend for blocks and backups
+	db             *ChainDB              // Local chain database, nil if not configured
+	loading        bool                  // Set while blocks are reloaded from the chain database
+	rejections     *RejectionLog         // Transactions refused or flagged by the validator model
+	state          *WorldState           // Balances and nonces after the latest block
+	index          map[string]*blockNode // Every known block by hash, side branches included
+	tip            *blockNode            // Main chain tip, the branch with the most work
+	orphans        *orphanPool           // Blocks waiting for their parent
+	tipChanged     chan struct{}         // Closed and replaced whenever the tip changes
+	reorgListeners []func(ReorgEvent)
+}
+
+// BlockchainConfig holds the settings used to construct a Blockchain
+type BlockchainConfig struct {
+	// Difficulty is the initial proof-of-work difficulty. Defaults to
+	// DefaultDifficulty.
+	Difficulty uint64
+	// TargetBlockTime and RetargetInterval control difficulty retargeting.
+	// They default to DefaultTargetBlockTime and DefaultRetargetInterval.
+	TargetBlockTime  time.Duration
+	RetargetInterval int64
+	TrainingFile     string
+	// Validator is the untrained transaction validator, such as an
+	// MLTransactionValidator or an MLPValidator. Defaults to an
+	// MLTransactionValidator.
+	Validator TransactionValidator
+	// ModelFile is a model saved with TransactionValidator.Save. When set,
+	// the validator is loaded from it instead of being trained on
+	// TrainingFile. Without a Validator, its kind is taken from the file.
+	ModelFile string
+	// ModelPromotionInterval enables online learning: the validator learns
+	// from every confirmed block, and every ModelPromotionInterval blocks the
+	// learned model becomes the one that blocks commit to. Zero keeps the
+	// genesis model. Requires an OnlineValidator. The interval is part of the
+	// model hash committed by the genesis block, so every node of a network
+	// must use the same one.
+	ModelPromotionInterval int64
+	// Store is the block storage backend. When nil, an IPFS node at
+	// localhost:5001 is used.
+	Store BlockStore
+	// GenesisAlloc is the initial balance of each address, recorded in the
+	// genesis block
+	GenesisAlloc map[string]float64
+	// MempoolSize caps the number of pending transactions. Defaults to
+	// DefaultMempoolSize.
+	MempoolSize int
+	// DataDir is the directory of the local chain database. Blocks are
+	// persisted there as they are added and reloaded on startup, and
+	// transactions rejected by the validator model are logged there. When
+	// empty, the chain and the rejection log are kept in memory only.
+	DataDir string
+	// DriftWindow enables drift monitoring: the features of the last
+	// DriftWindow transactions submitted for validation are compared with
+	// those of the validator's training data, which model files keep. Zero
+	// disables it.
+	DriftWindow int
+	// Velocity enables velocity checks: transactions are compared with the
+	// transfers of the last Velocity.Window blocks and the transactions
+	// accepted before them in the same batch, and flagged for review when they
+	// exceed its limits or close a transfer cycle. Unset settings take their
+	// defaults. Nil disables them.
+	Velocity *VelocityConfig
 }
 
 // Single NewBlockchain function that handles ML validator initialization
-func NewBlockchain(difficulty int, trainingFile string) (*Blockchain, error) {
-	validator := NewMLTransactionValidator()
-	err := validator.Train(trainingFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize ML validator: %v", err)
+func NewBlockchain(config BlockchainConfig) (*Blockchain, error) {
+	validator := config.Validator
+	var err error
+	if config.ModelFile != "" {
+		if validator == nil {
+			validator, err = LoadModelFile(config.ModelFile)
+		} else {
+			err = loadModelFile(validator, config.ModelFile)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load ML validator: %v", err)
+		}
+		fmt.Printf("Loaded ML model %s from %s\n", validator.ModelHash(), config.ModelFile)
+	} else {
+		if validator == nil {
+			validator = NewMLTransactionValidator()
+		}
+		_, err = validator.Train(config.TrainingFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize ML validator: %v", err)
+		}
+	}
+	if _, ok := validator.(OnlineValidator); config.ModelPromotionInterval > 0 && !ok {
+		return nil, fmt.Errorf("validator does not support online learning")
 	}
 
-	// Initialize IPFS handler
-	ipfsHandler, err := NewIPFSHandler("localhost:5001")
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize IPFS handler: %v", err)
+	var drift *DriftMonitor
+	if config.DriftWindow > 0 {
+		drift, err = NewDriftMonitor(validator, DriftConfig{Window: config.DriftWindow})
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize drift monitor: %v", err)
+		}
 	}
 
+	var velocity *VelocityConfig
+	if config.Velocity != nil {
+		vc := config.Velocity.withDefaults()
+		velocity = &vc
+	}
+
+	store := config.Store
+	if store == nil {
+		// Default to the IPFS handler
+		store, err = NewIPFSHandler("localhost:5001")
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize IPFS handler: %v", err)
+		}
+	}
+
+	models := newModelHistory(validator, config.ModelPromotionInterval)
 	blockchain := &Blockchain{
-		Blocks:      make([]*Block, 0),
-		Difficulty:  difficulty,
+		Blocks: make([]*Block, 0),
+		consensus: ConsensusParams{
+			InitialDifficulty: config.Difficulty,
+			TargetBlockTime:   config.TargetBlockTime,
+			RetargetInterval:  config.RetargetInterval,
+		}.withDefaults(),
 		MLValidator: validator,
-		ipfsHandler: ipfsHandler,
+		modelHash:   models.hashes[0],
+		models:      models,
+		Mempool:     NewTransactionPool(config.MempoolSize),
+		Drift:       drift,
+		velocity:    velocity,
+		store:       store,
+		state:       NewWorldState(),
+		index:       make(map[string]*blockNode),
+		orphans:     newOrphanPool(),
+		tipChanged:  make(chan struct{}),
 	}
 
 	// Create genesis block
-	genesisBlock := CreateBlock(0, []Transaction{}, "", difficulty)
-	blockchain.AddBlock(genesisBlock)
+	genesisBlock := CreateGenesisBlock(blockchain.consensus.InitialDifficulty, genesisAllocations(config.GenesisAlloc), blockchain.modelHash)
+	if err := blockchain.AddBlock(genesisBlock); err != nil {
+		return nil, fmt.Errorf("failed to add genesis block: %v", err)
+	}
+
+	rejectionLogPath := ""
+	if config.DataDir != "" {
+		if err := blockchain.openChainDB(config.DataDir); err != nil {
+			return nil, err
+		}
+		rejectionLogPath = filepath.Join(config.DataDir, rejectionLogFile)
+	}
+	blockchain.rejections, err = OpenRejectionLog(rejectionLogPath)
+	if err != nil {
+		blockchain.Close()
+		return nil, err
+	}
 
 	return blockchain, nil
 }
 
-// Method to validate transactions using ML
+// openChainDB reloads the blocks persisted in the chain database and then
+// attaches it so that new blocks are persisted as they are added
+func (bc *Blockchain) openChainDB(dir string) error {
+	db, err := OpenChainDB(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open chain database: %v", err)
+	}
+
+	blocks, err := db.Blocks()
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("failed to load chain database: %v", err)
+	}
+	// Side branch blocks that failed to apply once they had the most work
+	// are stored as well; they are rejected again here and skipped
+	bc.loading = true
+	for _, block := range blocks {
+		if err := bc.AddBlock(block); err != nil && !errors.Is(err, ErrKnownBlock) {
+			fmt.Printf("Skipping stored block %s: %v\n", block.Hash, err)
+		}
+	}
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.loading = false
+
+	genesis := bc.Blocks[0]
+	if err := db.PutBlock(genesis); err != nil {
+		db.Close()
+		return err
+	}
+	if err := db.SetTip(bc.tip.block.Hash); err != nil {
+		db.Close()
+		return err
+	}
+	bc.db = db
+	fmt.Printf("Loaded %d blocks from chain database, height %d\n", len(blocks), len(bc.Blocks)-1)
+	return nil
+}
+
+// Close releases the chain database and the rejection log
+func (bc *Blockchain) Close() error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	var err error
+	if bc.rejections != nil {
+		err = bc.rejections.Close()
+	}
+	if bc.db == nil {
+		return err
+	}
+	if dbErr := bc.db.Close(); dbErr != nil {
+		err = dbErr
+	}
+	bc.db = nil
+	return err
+}
+
+// Rejections returns the transactions most recently rejected or flagged by
+// the validator model, with the results explaining each decision
+func (bc *Blockchain) Rejections() []RejectedTransaction {
+	return bc.rejections.Recent()
+}
+
+// Method to validate transactions using ML. Transactions that can never
+// become valid are also dropped from the mempool. With velocity checks, each
+// transaction is also compared with the recent blocks and the transactions
+// accepted before it in the batch.
 func (bc *Blockchain) ValidateTransactionsML(transactions []Transaction) []Transaction {
 	validTransactions := make([]Transaction, 0)
 
+	// Transactions are applied in order to a scratch copy of the state so
+	// that overspends and replays within the batch are caught as well
+	bc.mutex.RLock()
+	state := bc.state.Copy()
+	validator, modelHash := bc.MLValidator, bc.modelHash
+	var graph *transferGraph
+	if bc.velocity != nil {
+		graph = newTransferGraph(*bc.velocity, bc.Blocks)
+	}
+	bc.mutex.RUnlock()
+
 	for _, tx := range transactions {
-		isValid, confidence, reason := bc.MLValidator.ValidateTransaction(tx)
-		if isValid {
+		// Only correctly signed transactions reach the ML validator
+		if err := tx.VerifySignature(); err != nil {
+			fmt.Printf("Transaction rejected: %v\n", err)
+			bc.Mempool.Remove(tx.Hash())
+			continue
+		}
+		if tx.Nonce < state.Nonce(tx.Sender) {
+			fmt.Printf("Transaction rejected: nonce %d from %s already used\n", tx.Nonce, tx.Sender)
+			bc.Mempool.Remove(tx.Hash())
+			continue
+		}
+		if err := state.CheckTransaction(tx); err != nil {
+			fmt.Printf("Transaction rejected: %v\n", err)
+			continue
+		}
+
+		if bc.Drift != nil {
+			bc.Drift.Observe(tx)
+		}
+		result := validator.Validate(tx)
+		if graph != nil {
+			result.addVelocity(graph.features(tx))
+		}
+		switch result.Band {
+		case BandAccept:
+			state.ApplyTransaction(tx)
 			validTransactions = append(validTransactions, tx)
-			fmt.Printf("Transaction validated (confidence: %.2f%%): %s\n", confidence*100, reason)
-		} else {
-			fmt.Printf("Transaction rejected (confidence: %.2f%%): %s\n", confidence*100, reason)
+			if graph != nil {
+				graph.add(tx)
+			}
+			fmt.Printf("Transaction validated (confidence: %.2f%%): %s, top factors: %v\n", result.Probability*100, result.Reason, result.TopFactors)
+		case BandFlag:
+			// Flagged transactions are still mined, but logged for review
+			state.ApplyTransaction(tx)
+			validTransactions = append(validTransactions, tx)
+			if graph != nil {
+				graph.add(tx)
+			}
+			fmt.Printf("Transaction %s\n", result)
+			if err := bc.rejections.Record(tx, result, modelHash); err != nil {
+				fmt.Printf("Error recording flagged transaction: %v\n", err)
+			}
+		default:
+			fmt.Printf("Transaction %s\n", result)
+			if err := bc.rejections.Record(tx, result, modelHash); err != nil {
+				fmt.Printf("Error recording rejected transaction: %v\n", err)
+			}
+			bc.Mempool.Remove(tx.Hash())
 		}
 	}
 
 	return validTransactions
 }
 
+// AddBlock validates a block and adds it to the block tree. A block extending
+// the tip is appended to the main chain; a block on another branch is kept and
+// the chain is reorganized onto that branch once it has more cumulative work.
+// A block whose parent is unknown is kept as an orphan and ErrOrphanBlock is
+// returned; orphans are connected as soon as their parent is added.
 func (bc *Blockchain) AddBlock(block *Block) error {
 	bc.mutex.Lock()
-	defer bc.mutex.Unlock()
+	var events []ReorgEvent
+	event, err := bc.addBlock(block)
+	if event != nil {
+		events = append(events, *event)
+	}
+	if err == nil {
+		events = append(events, bc.connectOrphans(block.Hash)...)
+	}
+	listeners := bc.reorgListeners
+	bc.mutex.Unlock()
 
-	if len(bc.Blocks) > 0 {
-		currentBlock := block
-		previousBlock := bc.Blocks[len(bc.Blocks)-1]
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+	return err
+}
 
-		if currentBlock.PrevHash != previousBlock.Hash {
-			return fmt.Errorf("invalid previous hash")
+// connectOrphans adds the orphans descending from a newly added block,
+// recursively. The caller must hold the write lock.
+func (bc *Blockchain) connectOrphans(hash string) []ReorgEvent {
+	var events []ReorgEvent
+	parents := []string{hash}
+	for len(parents) > 0 {
+		parent := parents[0]
+		parents = parents[1:]
+
+		for _, orphan := range bc.orphans.takeChildren(parent) {
+			event, err := bc.addBlock(orphan)
+			if err != nil {
+				fmt.Printf("Dropping orphan block %s: %v\n", orphan.Hash, err)
+				continue
+			}
+			fmt.Printf("Connected orphan block %d: %s\n", orphan.Index, orphan.Hash)
+			if event != nil {
+				events = append(events, *event)
+			}
+			parents = append(parents, orphan.Hash)
 		}
+	}
+	return events
+}
 
-		if !currentBlock.ValidateBlock() {
-			return fmt.Errorf("invalid block proof of work")
+// MissingAncestor returns the hash of the unknown block an orphan ultimately
+// descends from, which is what has to be requested from peers
+func (bc *Blockchain) MissingAncestor(hash string) string {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.orphans.missingAncestor(hash)
+}
+
+// addBlock does the work of AddBlock. The caller must hold the write lock.
+func (bc *Blockchain) addBlock(block *Block) (*ReorgEvent, error) {
+	if _, exists := bc.index[block.Hash]; exists {
+		return nil, ErrKnownBlock
+	}
+
+	var parent *blockNode
+	if bc.tip != nil {
+		if block.Index == 0 {
+			return nil, fmt.Errorf("block has a different genesis block")
+		}
+
+		var ok bool
+		parent, ok = bc.index[block.PrevHash]
+		if !ok {
+			// Only keep orphans that carry valid proof of work at a
+			// difficulty the main chain could plausibly have reached, so
+			// cheap blocks cannot push real orphans out of the pool
+			if minimum := bc.minOrphanDifficulty(); block.Difficulty < minimum {
+				return nil, fmt.Errorf("orphan block difficulty %d below minimum %d", block.Difficulty, minimum)
+			}
+			if !block.ValidateBlock() {
+				return nil, fmt.Errorf("invalid block proof of work")
+			}
+			bc.orphans.add(block)
+			return nil, ErrOrphanBlock
+		}
+		if invalid := bc.invalidAncestor(parent); invalid != nil {
+			return nil, fmt.Errorf("block builds on invalid block %s", invalid.block.Hash)
+		}
+
+		if block.Index != parent.block.Index+1 {
+			return nil, fmt.Errorf("invalid block index %d", block.Index)
+		}
+
+		if expected := bc.expectedDifficulty(bc.branch(parent)); block.Difficulty != expected {
+			return nil, fmt.Errorf("invalid block difficulty %d, expected %d", block.Difficulty, expected)
+		}
+
+		if err := checkTimestamp(block, parent.block); err != nil {
+			return nil, err
+		}
+
+		if !block.ValidateBlock() {
+			return nil, fmt.Errorf("invalid block proof of work")
+		}
+
+		if err := verifyTransactionSignatures(block); err != nil {
+			return nil, err
+		}
+
+		if err := checkModel(block, bc.modelHistoryAt(parent)); err != nil {
+			return nil, err
 		}
 	}
 
-	// Store block in IPFS
-	ipfsHash, err := bc.ipfsHandler.StoreBlock(block)
-	if err != nil {
-		return fmt.Errorf("failed to store block in IPFS: %v", err)
+	// Apply a block extending the tip to a copy of the state so a failing
+	// transaction leaves the current state untouched. Blocks on other
+	// branches are checked against their own state when they take over.
+	var newState *WorldState
+	if parent == bc.tip {
+		newState = NewWorldState()
+		if parent != nil {
+			newState = bc.state.Copy()
+		}
+		if err := newState.ApplyBlock(block); err != nil {
+			return nil, fmt.Errorf("invalid block state transition: %v", err)
+		}
+	}
+
+	// Store block in the block store. Blocks reloaded from the chain database
+	// were stored and pinned when they were first added.
+	if !bc.loading {
+		storeHash, err := bc.store.StoreBlock(block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store block: %v", err)
+		}
+
+		// Pin the block to ensure it's kept in the store
+		if err := bc.store.Pin(storeHash); err != nil {
+			return nil, fmt.Errorf("failed to pin block: %v", err)
+		}
+
+		fmt.Printf("Block stored with hash: %s\n", storeHash)
 	}
 
-	// Pin the block to ensure it's kept in the network
-	if err := bc.ipfsHandler.Pin(ipfsHash); err != nil {
-		return fmt.Errorf("failed to pin block in IPFS: %v", err)
+	if bc.db != nil {
+		if err := bc.db.PutBlock(block); err != nil {
+			return nil, err
+		}
+	}
+
+	node := newBlockNode(block, parent)
+	if parent == bc.tip {
+		if err := bc.commitTip(node); err != nil {
+			return nil, err
+		}
+		bc.index[block.Hash] = node
+		bc.Blocks = append(bc.Blocks, block)
+		bc.state = newState
+		bc.tip = node
+		if block.Index > 0 && bc.models.connect(block) {
+			fmt.Printf("Validator model %s promoted at block %d\n", bc.models.hashes[len(bc.models.hashes)-1], block.Index)
+		}
+		bc.updateActiveModel()
+		bc.Mempool.RemoveIncluded(block, newState)
+		bc.notifyTipChange()
+		return nil, nil
+	}
+
+	bc.index[block.Hash] = node
+	if node.work.Cmp(bc.tip.work) <= 0 {
+		fmt.Printf("Block %d stored on a side branch: %s\n", block.Index, block.Hash)
+		return nil, nil
 	}
+	return bc.reorganize(node)
+}
+
+// commitTip records a new main chain tip in the chain database, if any. The
+// caller must hold the write lock.
+func (bc *Blockchain) commitTip(node *blockNode) error {
+	if bc.db == nil {
+		return nil
+	}
+	return bc.db.SetTip(node.block.Hash)
+}
+
+// GetTransaction looks up a main chain transaction by ID in the chain database
+func (bc *Blockchain) GetTransaction(txID string) (*Transaction, TxLocation, error) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
 
-	fmt.Printf("Block stored in IPFS with hash: %s\n", ipfsHash)
+	if bc.db == nil {
+		return nil, TxLocation{}, fmt.Errorf("no chain database configured")
+	}
+	return bc.db.Transaction(txID)
+}
 
-	bc.Blocks = append(bc.Blocks, block)
+// AddBlocks adds a sequence of blocks, such as a peer's chain, skipping the
+// ones already known. The main chain moves to them if they carry more work.
+func (bc *Blockchain) AddBlocks(blocks []*Block) error {
+	for _, block := range blocks {
+		if err := bc.AddBlock(block); err != nil && !errors.Is(err, ErrKnownBlock) {
+			return fmt.Errorf("block %d: %v", block.Index, err)
+		}
+	}
 	return nil
 }
 
+// notifyTipChange wakes everyone waiting on the current tip. The caller must
+// hold the write lock.
+func (bc *Blockchain) notifyTipChange() {
+	close(bc.tipChanged)
+	bc.tipChanged = make(chan struct{})
+}
+
+// TipChanged returns a channel that is closed when the current tip is
+// replaced by another block
+func (bc *Blockchain) TipChanged() <-chan struct{} {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.tipChanged
+}
+
+// NewBlockTemplate builds an unmined block with the given transactions on top
+// of the current tip, along with the channel that is closed once that tip is
+// no longer current
+func (bc *Blockchain) NewBlockTemplate(transactions []Transaction) (*Block, <-chan struct{}) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	timestamp := time.Now().Unix()
+	if timestamp < tip.Timestamp {
+		timestamp = tip.Timestamp
+	}
+	block := newBlock(tip.Index+1, timestamp, transactions, tip.Hash, bc.expectedDifficulty(bc.Blocks), bc.modelHash)
+	return block, bc.tipChanged
+}
+
+// GetBalance returns the balance of an address after the latest block
+func (bc *Blockchain) GetBalance(addr string) float64 {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.state.Balance(addr)
+}
+
+// GetNonce returns the nonce the next transaction from an address must use
+func (bc *Blockchain) GetNonce(addr string) uint64 {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.state.Nonce(addr)
+}
+
 func (bc *Blockchain) GetLatestBlock() *Block {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
@@ -108,64 +582,246 @@ func (bc *Blockchain) IsValid() bool {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
 
-	for i := 1; i < len(bc.Blocks); i++ {
-		currentBlock := bc.Blocks[i]
-		previousBlock := bc.Blocks[i-1]
-
-		if currentBlock.PrevHash != previousBlock.Hash {
-			return false
-		}
-
-		if !currentBlock.ValidateBlock() {
-			return false
-		}
-	}
-	return true
+	return len(bc.Blocks) == 0 || bc.validateChain(bc.Blocks) == nil
 }
 
-// New method to backup blockchain to IPFS
+// BackupToIPFS stores a snapshot of the blockchain in the block store
 func (bc *Blockchain) BackupToIPFS() (string, error) {
 	bc.mutex.RLock()
 	defer bc.mutex.RUnlock()
 
-	hash, err := bc.ipfsHandler.StoreBlockchain(bc)
+	hash, err := bc.store.StoreBlockchain(bc.Blocks)
 	if err != nil {
-		return "", fmt.Errorf("failed to backup blockchain to IPFS: %v", err)
+		return "", fmt.Errorf("failed to backup blockchain: %v", err)
 	}
 
-	if err := bc.ipfsHandler.Pin(hash); err != nil {
+	if err := bc.store.Pin(hash); err != nil {
 		return "", fmt.Errorf("failed to pin blockchain backup: %v", err)
 	}
 
-	fmt.Printf("Blockchain backed up to IPFS with hash: %s\n", hash)
+	fmt.Printf("Blockchain backed up with hash: %s\n", hash)
 	return hash, nil
 }
 
-// New method to restore blockchain from IPFS
-func (bc *Blockchain) RestoreFromIPFS(hash string) error {
-	bc.mutex.Lock()
-	defer bc.mutex.Unlock()
+// RetrieveBackup retrieves a blockchain snapshot from the block store
+// without applying it
+func (bc *Blockchain) RetrieveBackup(hash string) ([]*Block, error) {
+	return bc.store.RetrieveBlockchain(hash)
+}
 
-	blocks, err := bc.ipfsHandler.RetrieveBlockchain(hash)
+// RestoreFromIPFS adds the blocks of a snapshot in the block store. The main
+// chain switches to the snapshot if it carries more work.
+func (bc *Blockchain) RestoreFromIPFS(hash string) error {
+	blocks, err := bc.store.RetrieveBlockchain(hash)
 	if err != nil {
-		return fmt.Errorf("failed to restore blockchain from IPFS: %v", err)
+		return fmt.Errorf("failed to restore blockchain: %v", err)
+	}
+
+	if err := bc.AddBlocks(blocks); err != nil {
+		return fmt.Errorf("invalid blockchain data: %v", err)
+	}
+	fmt.Printf("Blockchain restored from backup hash: %s\n", hash)
+	return nil
+}
+
+// GetBlocks returns a copy of the current chain
+func (bc *Blockchain) GetBlocks() []*Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	blocks := make([]*Block, len(bc.Blocks))
+	copy(blocks, bc.Blocks)
+	return blocks
+}
+
+// GetMerkleProof returns the proof that a transaction is included in a block
+// of the chain. It can be checked against the block header alone with
+// VerifyMerkleProof.
+func (bc *Blockchain) GetMerkleProof(blockHash, txHash string) (*MerkleProof, error) {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	for _, block := range bc.Blocks {
+		if block.Hash != blockHash {
+			continue
+		}
+
+		txHashes := block.TransactionHashes()
+		for i, hash := range txHashes {
+			if hash == txHash {
+				proof, err := BuildMerkleProof(txHashes, i)
+				if err != nil {
+					return nil, err
+				}
+				proof.BlockHash = blockHash
+				return proof, nil
+			}
+		}
+		return nil, fmt.Errorf("transaction %s not found in block %s", txHash, blockHash)
+	}
+	return nil, fmt.Errorf("block %s not found", blockHash)
+}
+
+// GenesisHash returns the hash of the first block in the chain
+func (bc *Blockchain) GenesisHash() string {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	if len(bc.Blocks) == 0 {
+		return ""
+	}
+	return bc.Blocks[0].Hash
+}
+
+// Height returns the index of the latest block
+func (bc *Blockchain) Height() int {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return len(bc.Blocks) - 1
+}
+
+// NextDifficulty returns the difficulty required for the next block
+func (bc *Blockchain) NextDifficulty() uint64 {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.expectedDifficulty(bc.Blocks)
+}
+
+// expectedDifficulty returns the difficulty the consensus rules require for
+// the block following the given chain. Every node recomputes it from the
+// chain itself, so blocks cannot choose their own difficulty.
+func (bc *Blockchain) expectedDifficulty(chain []*Block) uint64 {
+	return bc.consensus.NextDifficulty(chain)
+}
+
+// minOrphanDifficulty is the lowest difficulty accepted for an orphan: the
+// difficulty expected after the tip, lowered by one maximal retarget. The
+// caller must hold the lock.
+func (bc *Blockchain) minOrphanDifficulty() uint64 {
+	minimum := bc.expectedDifficulty(bc.Blocks) / maxRetargetFactor
+	if minimum == 0 {
+		minimum = 1
+	}
+	return minimum
+}
+
+// checkTimestamp rejects blocks dated before their parent or too far in the
+// future, which would otherwise let miners skew retargeting
+func checkTimestamp(block, parent *Block) error {
+	if block.Timestamp < parent.Timestamp {
+		return fmt.Errorf("block timestamp %d is before its parent's", block.Timestamp)
+	}
+	if block.Timestamp > time.Now().Add(MaxFutureBlockTime).Unix() {
+		return fmt.Errorf("block timestamp %d is too far in the future", block.Timestamp)
+	}
+	return nil
+}
+
+// validateChain checks the hash links, committed difficulty and proof of work
+// of a list of blocks
+func (bc *Blockchain) validateChain(blocks []*Block) error {
+	if len(blocks) == 0 {
+		return fmt.Errorf("empty chain")
+	}
+
+	genesis := blocks[0]
+	models := bc.models.rollback(blocks, 0)
+	if genesis.Index != 0 || genesis.Difficulty != bc.expectedDifficulty(nil) || genesis.ModelHash != models.hashes[0] || !genesis.ValidateBlock() {
+		return fmt.Errorf("invalid genesis block")
 	}
 
-	// Validate the retrieved blockchain
 	for i := 1; i < len(blocks); i++ {
 		currentBlock := blocks[i]
 		previousBlock := blocks[i-1]
 
 		if currentBlock.PrevHash != previousBlock.Hash {
-			return fmt.Errorf("invalid blockchain data: hash mismatch at block %d", i)
+			return fmt.Errorf("hash mismatch at block %d", i)
+		}
+
+		if currentBlock.Index != previousBlock.Index+1 {
+			return fmt.Errorf("invalid index at block %d", i)
+		}
+
+		if expected := bc.expectedDifficulty(blocks[:i]); currentBlock.Difficulty != expected {
+			return fmt.Errorf("invalid difficulty %d at block %d, expected %d", currentBlock.Difficulty, i, expected)
+		}
+
+		if err := checkTimestamp(currentBlock, previousBlock); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
 		}
 
 		if !currentBlock.ValidateBlock() {
-			return fmt.Errorf("invalid blockchain data: invalid proof of work at block %d", i)
+			return fmt.Errorf("invalid proof of work at block %d", i)
+		}
+
+		if err := verifyTransactionSignatures(currentBlock); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+
+		if err := checkModel(currentBlock, models); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+		models.connect(currentBlock)
+	}
+	return nil
+}
+
+// checkModel checks that a block commits to the validator model of its
+// chain, given the model history up to its parent, and that the model
+// accepts every transaction in it. Scores are computed in fixed point, so all
+// nodes reach the same verdict.
+func checkModel(block *Block, models *modelHistory) error {
+	validator, modelHash := models.active(block.Index)
+	if block.ModelVersion != ValidatorVersion || block.ModelHash != modelHash {
+		return fmt.Errorf("block commits to validator model %d/%s, expected %d/%s",
+			block.ModelVersion, block.ModelHash, ValidatorVersion, modelHash)
+	}
+	for i, tx := range block.Transactions {
+		if result := validator.Validate(tx); !result.Valid {
+			return fmt.Errorf("transaction %d rejected by the validator model (confidence: %.2f%%): %s", i, result.Probability*100, result.Reason)
+		}
+	}
+	return nil
+}
+
+// modelHistoryAt returns the validator model history of the branch ending at
+// node. A side branch rolls the history back to its fork point and learns
+// from its own blocks; the result is cached on each side-branch node, so a
+// block extending one learns only from its parent. The caller must hold the
+// write lock.
+func (bc *Blockchain) modelHistoryAt(node *blockNode) *modelHistory {
+	if node == bc.tip || bc.onMainChain(node) {
+		node.models = nil
+		return bc.models
+	}
+	if node.models == nil {
+		if bc.onMainChain(node.parent) {
+			node.models = bc.models.rollback(bc.Blocks, node.parent.block.Index).extend(node.block)
+		} else {
+			node.models = bc.modelHistoryAt(node.parent).extend(node.block)
 		}
 	}
+	return node.models
+}
 
-	bc.Blocks = blocks
-	fmt.Printf("Blockchain restored from IPFS hash: %s\n", hash)
+// updateActiveModel makes the model that the next block commits to the one
+// used to validate transactions. The caller must hold the write lock.
+func (bc *Blockchain) updateActiveModel() {
+	validator, modelHash := bc.models.active(bc.tip.block.Index + 1)
+	if modelHash == bc.modelHash {
+		return
+	}
+	bc.MLValidator = validator
+	bc.modelHash = modelHash
+	fmt.Printf("Validator model %s active from block %d\n", modelHash, bc.tip.block.Index+1)
+}
+
+// verifyTransactionSignatures checks the signature of every transaction in a block
+func verifyTransactionSignatures(block *Block) error {
+	for i, tx := range block.Transactions {
+		if err := tx.VerifySignature(); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
 	return nil
 }
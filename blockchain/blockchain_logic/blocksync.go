@@ -0,0 +1,166 @@
+// blocksync.go
+package blockchain_logic
+
+import "fmt"
+
+const (
+	// maxBlocksPerMessage caps the number of blocks sent in one BLOCKS message
+	maxBlocksPerMessage = 100
+	// maxHeadersPerMessage caps the number of headers sent in one HEADERS message
+	maxHeadersPerMessage = 500
+)
+
+// Chain sync is headers first. A node behind a peer sends GET_HEADERS with a
+// block locator; the peer finds the fork point from it and answers with the
+// headers of its main chain after that point. The node checks the headers,
+// requests the blocks it lacks in batches with GET_BLOCKS, and asks for more
+// headers while the peer keeps sending full batches.
+
+// GetHeadersPayload asks a peer for the main chain headers that follow the
+// fork point identified by Locator, up to and including Stop
+type GetHeadersPayload struct {
+	Locator []string `json:"locator"`
+	Stop    string   `json:"stop,omitempty"`
+}
+
+// GetBlocksPayload asks a peer for the main chain blocks that follow the fork
+// point identified by Locator, up to and including Stop
+type GetBlocksPayload struct {
+	Locator []string `json:"locator"`
+	Stop    string   `json:"stop,omitempty"`
+}
+
+// startSync asks a peer that is ahead of us for its headers
+func (pn *PeerNetwork) startSync(peer *PeerConnection) {
+	if pn.blockchain == nil || peer.BestHeight <= pn.blockchain.Height() {
+		return
+	}
+	fmt.Printf("Syncing with %s: local height %d, peer height %d\n",
+		peer.Address, pn.blockchain.Height(), peer.BestHeight)
+	pn.requestHeaders(peer, pn.blockchain.BlockLocator())
+}
+
+// requestHeaders sends a GET_HEADERS request
+func (pn *PeerNetwork) requestHeaders(peer *PeerConnection, locator []string) {
+	request := BlockchainMessage{
+		Type:    MessageTypeGetHeaders,
+		Content: GetHeadersPayload{Locator: locator},
+		From:    pn.MyAddress,
+		To:      peer.Address,
+	}
+	if err := peer.Send(request); err != nil {
+		fmt.Printf("Error requesting headers from %s: %v\n", peer.Address, err)
+	}
+}
+
+// handleGetHeaders answers a GET_HEADERS request with a HEADERS message
+func (pn *PeerNetwork) handleGetHeaders(request *GetHeadersPayload, peer *PeerConnection) {
+	headers := pn.blockchain.LocateHeaders(request.Locator, request.Stop, maxHeadersPerMessage)
+	response := BlockchainMessage{
+		Type:    MessageTypeHeaders,
+		Content: headers,
+		From:    pn.MyAddress,
+		To:      peer.Address,
+	}
+	if err := peer.Send(response); err != nil {
+		fmt.Printf("Error sending headers to %s: %v\n", peer.Address, err)
+	}
+}
+
+// handleHeaders checks the received headers and requests the blocks we lack
+func (pn *PeerNetwork) handleHeaders(headers []BlockHeader, peer *PeerConnection) {
+	if len(headers) == 0 {
+		return
+	}
+	if err := pn.blockchain.CheckHeaders(headers); err != nil {
+		fmt.Printf("Ignoring headers from %s: %v\n", peer.Address, err)
+		return
+	}
+
+	var missing []BlockHeader
+	for _, header := range headers {
+		if !pn.blockchain.HasBlock(header.Hash) {
+			missing = append(missing, header)
+		}
+	}
+	fmt.Printf("Received %d headers from %s, %d blocks missing\n", len(headers), peer.Address, len(missing))
+
+	// The headers are consecutive, so each batch is named by the parent of
+	// its first block and the hash of its last one
+	for start := 0; start < len(missing); start += maxBlocksPerMessage {
+		end := start + maxBlocksPerMessage
+		if end > len(missing) {
+			end = len(missing)
+		}
+		pn.requestBlocks(peer, []string{missing[start].PrevHash}, missing[end-1].Hash)
+	}
+
+	// A full batch means the peer has more headers to send
+	if len(headers) == maxHeadersPerMessage {
+		pn.requestHeaders(peer, []string{headers[len(headers)-1].Hash})
+	}
+}
+
+// requestMissingBlocks asks the peer that sent an orphan for the blocks
+// between our main chain and the orphan's missing ancestor. A gap longer than
+// one batch is requested again by handleBlocks.
+func (pn *PeerNetwork) requestMissingBlocks(peer *PeerConnection, orphan *Block) {
+	peer.wantedOrphan = orphan.Hash
+	pn.requestBlocks(peer, pn.blockchain.BlockLocator(), pn.blockchain.MissingAncestor(orphan.Hash))
+}
+
+// requestBlocks sends a GET_BLOCKS request for the blocks following the fork
+// point identified by locator, up to stop
+func (pn *PeerNetwork) requestBlocks(peer *PeerConnection, locator []string, stop string) {
+	request := BlockchainMessage{
+		Type: MessageTypeGetBlocks,
+		Content: GetBlocksPayload{
+			Locator: locator,
+			Stop:    stop,
+		},
+		From: pn.MyAddress,
+		To:   peer.Address,
+	}
+	if err := peer.Send(request); err != nil {
+		fmt.Printf("Error requesting blocks from %s: %v\n", peer.Address, err)
+	}
+}
+
+// handleGetBlocks answers a GET_BLOCKS request with a BLOCKS message
+func (pn *PeerNetwork) handleGetBlocks(request *GetBlocksPayload, peer *PeerConnection) {
+	blocks := pn.blockchain.LocateBlocks(request.Locator, request.Stop, maxBlocksPerMessage)
+	if len(blocks) == 0 {
+		return
+	}
+
+	response := BlockchainMessage{
+		Type:    MessageTypeBlocks,
+		Content: blocks,
+		From:    pn.MyAddress,
+		To:      peer.Address,
+	}
+	if err := peer.Send(response); err != nil {
+		fmt.Printf("Error sending blocks to %s: %v\n", peer.Address, err)
+	}
+}
+
+// handleBlocks adds the blocks received in a BLOCKS message
+func (pn *PeerNetwork) handleBlocks(blocks []*Block, peer *PeerConnection) {
+	fmt.Printf("Received %d blocks from %s\n", len(blocks), peer.Address)
+	if err := pn.blockchain.AddBlocks(blocks); err != nil {
+		fmt.Printf("Error adding blocks from %s: %v\n", peer.Address, err)
+		return
+	}
+
+	// While the requested orphan is still waiting for its ancestors, a full
+	// batch means the peer has more of them: ask again from the new tip
+	if peer.wantedOrphan == "" {
+		return
+	}
+	missing := pn.blockchain.MissingAncestor(peer.wantedOrphan)
+	if missing == peer.wantedOrphan {
+		peer.wantedOrphan = "" // Connected, or dropped from the orphan pool
+	} else if len(blocks) == maxBlocksPerMessage {
+		pn.requestBlocks(peer, pn.blockchain.BlockLocator(), missing)
+	}
+}
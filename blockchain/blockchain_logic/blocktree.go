@@ -0,0 +1,285 @@
+// blocktree.go
+package blockchain_logic
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrKnownBlock is returned when a block is already in the block tree
+var ErrKnownBlock = errors.New("block already known")
+
+// blockNode is a block in the tree of every known block, side branches included
+type blockNode struct {
+	block   *Block
+	parent  *blockNode
+	work    *big.Int      // Cumulative work from the genesis block
+	invalid bool          // Set when the block failed to apply to the state
+	models  *modelHistory // Validator model history of a side branch ending here, cached
+}
+
+// newBlockNode creates the tree node of a block whose parent is already known
+func newBlockNode(block *Block, parent *blockNode) *blockNode {
+	work := block.Work()
+	if parent != nil {
+		work.Add(work, parent.work)
+	}
+	return &blockNode{
+		block:  block,
+		parent: parent,
+		work:   work,
+	}
+}
+
+// chain returns the blocks from the genesis block up to the node
+func (n *blockNode) chain() []*Block {
+	blocks := make([]*Block, n.block.Index+1)
+	for node := n; node != nil; node = node.parent {
+		blocks[node.block.Index] = node.block
+	}
+	return blocks
+}
+
+// findForkPoint returns the last block two branches have in common
+func findForkPoint(a, b *blockNode) *blockNode {
+	for a.block.Index > b.block.Index {
+		a = a.parent
+	}
+	for b.block.Index > a.block.Index {
+		b = b.parent
+	}
+	for a != b {
+		a, b = a.parent, b.parent
+	}
+	return a
+}
+
+// ReorgEvent describes a switch of the main chain to a branch with more work
+type ReorgEvent struct {
+	OldTip       *Block
+	NewTip       *Block
+	ForkPoint    *Block   // Last block shared by both branches
+	Disconnected []*Block // Blocks removed from the main chain, tip first
+	Connected    []*Block // Blocks added to the main chain, in chain order
+}
+
+// OnReorg registers a function that is called after every chain
+// reorganization. It runs without the blockchain lock held.
+func (bc *Blockchain) OnReorg(listener func(ReorgEvent)) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.reorgListeners = append(bc.reorgListeners, listener)
+}
+
+// branch returns the chain ending at a node, reusing the main chain when the
+// node is the tip
+func (bc *Blockchain) branch(node *blockNode) []*Block {
+	if node == bc.tip {
+		return bc.Blocks
+	}
+	return node.chain()
+}
+
+// reorganize switches the main chain to the branch ending at node. The state
+// is rebuilt by replaying the new chain; if one of its blocks does not apply,
+// that block and the blocks above it are marked invalid and the current chain
+// is kept. The validator model is rolled back to the fork point.
+// Transactions of disconnected blocks go back to the mempool. The caller must
+// hold the write lock.
+func (bc *Blockchain) reorganize(node *blockNode) (*ReorgEvent, error) {
+	newChain := node.chain()
+	state := NewWorldState()
+	for _, block := range newChain {
+		if err := state.ApplyBlock(block); err != nil {
+			// The blocks built on it are invalid too; blocks on other
+			// branches above it are caught by invalidAncestor
+			for _, descendant := range newChain[block.Index:] {
+				bc.index[descendant.Hash].invalid = true
+			}
+			return nil, fmt.Errorf("invalid block state transition at block %d: %v", block.Index, err)
+		}
+	}
+
+	if err := bc.commitTip(node); err != nil {
+		return nil, err
+	}
+
+	// Roll the validator model back to the fork point, dropping what it
+	// learned from the disconnected blocks, and learn the new branch
+	fork := findForkPoint(bc.tip, node)
+	models := bc.models.rollback(newChain, fork.block.Index)
+	for _, block := range newChain[fork.block.Index+1:] {
+		if models.connect(block) {
+			fmt.Printf("Validator model %s promoted at block %d\n", models.hashes[len(models.hashes)-1], block.Index)
+		}
+	}
+	event := &ReorgEvent{
+		OldTip:    bc.tip.block,
+		NewTip:    node.block,
+		ForkPoint: fork.block,
+		Connected: append([]*Block(nil), newChain[fork.block.Index+1:]...),
+	}
+	for n := bc.tip; n != fork; n = n.parent {
+		event.Disconnected = append(event.Disconnected, n.block)
+	}
+
+	bc.Blocks = newChain
+	bc.state = state
+	bc.tip = node
+	bc.models = models
+	bc.updateActiveModel()
+
+	// Transactions that the new branch also includes are evicted again below
+	for _, block := range event.Disconnected {
+		for _, tx := range block.Transactions {
+			bc.Mempool.Add(tx)
+		}
+	}
+	for _, block := range event.Connected {
+		bc.Mempool.RemoveIncluded(block, state)
+	}
+	bc.notifyTipChange()
+
+	fmt.Printf("Chain reorganized at block %d: %d blocks disconnected, %d connected, new height %d\n",
+		fork.block.Index, len(event.Disconnected), len(event.Connected), node.block.Index)
+	return event, nil
+}
+
+// invalidAncestor returns the nearest block at or below node that is marked
+// invalid, or nil if there is none. Blocks in between are marked invalid as
+// well, so later blocks on the branch stop at their parent. Main chain blocks
+// all applied, so the walk ends at the main chain. The caller must hold the
+// write lock.
+func (bc *Blockchain) invalidAncestor(node *blockNode) *blockNode {
+	var walked []*blockNode
+	for n := node; n != nil && !bc.onMainChain(n); n = n.parent {
+		if n.invalid {
+			for _, descendant := range walked {
+				descendant.invalid = true
+			}
+			return n
+		}
+		walked = append(walked, n)
+	}
+	return nil
+}
+
+// maxLocatorDenseHashes is the number of most recent blocks listed one by one
+// in a block locator before the step size starts doubling
+const maxLocatorDenseHashes = 10
+
+// onMainChain reports whether a node is part of the main chain. The caller
+// must hold the lock.
+func (bc *Blockchain) onMainChain(node *blockNode) bool {
+	index := node.block.Index
+	return index < int64(len(bc.Blocks)) && bc.Blocks[index] == node.block
+}
+
+// BlockLocator summarizes the main chain for a peer looking for the fork
+// point: the hashes of the most recent blocks, then of blocks exponentially
+// further back, ending with the genesis block
+func (bc *Blockchain) BlockLocator() []string {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	var locator []string
+	step := 1
+	for index := len(bc.Blocks) - 1; index > 0; index -= step {
+		locator = append(locator, bc.Blocks[index].Hash)
+		if len(locator) >= maxLocatorDenseHashes {
+			step *= 2
+		}
+	}
+	if len(bc.Blocks) > 0 {
+		locator = append(locator, bc.Blocks[0].Hash)
+	}
+	return locator
+}
+
+// LocateBlocks returns up to max main chain blocks following the first
+// locator hash found on the main chain, stopping after the block with the
+// stop hash. If no locator hash is known, it starts after the genesis block.
+func (bc *Blockchain) LocateBlocks(locator []string, stop string, max int) []*Block {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+	return bc.locate(locator, stop, max)
+}
+
+// LocateHeaders is LocateBlocks for headers only
+func (bc *Blockchain) LocateHeaders(locator []string, stop string, max int) []BlockHeader {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	blocks := bc.locate(locator, stop, max)
+	headers := make([]BlockHeader, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.BlockHeader
+	}
+	return headers
+}
+
+// locate does the work of LocateBlocks. The caller must hold the lock.
+func (bc *Blockchain) locate(locator []string, stop string, max int) []*Block {
+	start := int64(0)
+	for _, hash := range locator {
+		if node, ok := bc.index[hash]; ok && bc.onMainChain(node) {
+			start = node.block.Index
+			break
+		}
+	}
+
+	var blocks []*Block
+	for index := start + 1; index < int64(len(bc.Blocks)) && len(blocks) < max; index++ {
+		blocks = append(blocks, bc.Blocks[index])
+		if bc.Blocks[index].Hash == stop {
+			break
+		}
+	}
+	return blocks
+}
+
+// HasBlock reports whether a block is in the block tree
+func (bc *Blockchain) HasBlock(hash string) bool {
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	_, exists := bc.index[hash]
+	return exists
+}
+
+// CheckHeaders checks that a list of headers forms a chain attached to a known
+// block and that every header carries valid proof of work at the difficulty
+// the consensus rules require along that chain. The remaining rules are
+// enforced once the blocks themselves arrive.
+func (bc *Blockchain) CheckHeaders(headers []BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	bc.mutex.RLock()
+	defer bc.mutex.RUnlock()
+
+	parent, ok := bc.index[headers[0].PrevHash]
+	if !ok {
+		return fmt.Errorf("headers do not connect to a known block")
+	}
+	// Extend a copy of the parent's branch with the headers, so each header's
+	// difficulty is checked against the chain below it
+	chain := append([]*Block(nil), bc.branch(parent)...)
+
+	for i := range headers {
+		header := &headers[i]
+		if header.PrevHash != chain[len(chain)-1].Hash || header.Index != chain[len(chain)-1].Index+1 {
+			return fmt.Errorf("header %d does not follow the previous header", header.Index)
+		}
+		if expected := bc.expectedDifficulty(chain); header.Difficulty != expected {
+			return fmt.Errorf("invalid difficulty %d in header %d, expected %d", header.Difficulty, header.Index, expected)
+		}
+		if header.CalculateHash() != header.Hash || !meetsTarget(header.Hash, header.Target()) {
+			return fmt.Errorf("invalid proof of work in header %d", header.Index)
+		}
+		chain = append(chain, &Block{BlockHeader: *header})
+	}
+	return nil
+}
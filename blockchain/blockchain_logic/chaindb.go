@@ -0,0 +1,383 @@
+// chaindb.go
+package blockchain_logic
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Chain database files
+const (
+	chainDBDataFile  = "blocks.dat" // Append-only block records, one JSON object per line
+	chainDBIndexFile = "blocks.idx" // Append-only journal locating each record in blocks.dat
+	chainDBTipFile   = "TIP"        // Hash of the main chain tip, replaced atomically
+)
+
+// ErrNotFound is returned when a block or transaction is not in the database
+var ErrNotFound = errors.New("not found")
+
+// chainDBEntry locates a stored block
+type chainDBEntry struct {
+	Hash     string `json:"hash"`
+	PrevHash string `json:"prev_hash"`
+	Height   int64  `json:"height"`
+	Offset   int64  `json:"offset"`
+	Length   int64  `json:"length"`
+}
+
+// TxLocation is where a transaction was included in the main chain
+type TxLocation struct {
+	BlockHash string `json:"block_hash"`
+	Height    int64  `json:"height"`
+	Position  int    `json:"position"` // Index of the transaction in the block
+}
+
+// ChainDB is an embedded on-disk store for every block the node accepted,
+// side branches included. It keeps indexes from block hash to record, from
+// height to main chain block and from transaction ID to its main chain block.
+// The hash index is journaled; the others are rebuilt from the tip on open.
+type ChainDB struct {
+	dir       string
+	dataFile  *os.File
+	indexFile *os.File
+	entries   map[string]*chainDBEntry // All stored blocks by hash
+	order     []*chainDBEntry          // Storage order, parents before children
+	heights   []string                 // Main chain block hashes by height
+	txIndex   map[string]TxLocation    // Main chain transactions by ID
+	mutex     sync.RWMutex
+}
+
+// OpenChainDB opens or creates the chain database in dir
+func OpenChainDB(dir string) (*ChainDB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create chain database directory: %v", err)
+	}
+
+	dataFile, err := os.OpenFile(filepath.Join(dir, chainDBDataFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block data: %v", err)
+	}
+	indexFile, err := os.OpenFile(filepath.Join(dir, chainDBIndexFile), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		dataFile.Close()
+		return nil, fmt.Errorf("failed to open block index: %v", err)
+	}
+
+	db := &ChainDB{
+		dir:       dir,
+		dataFile:  dataFile,
+		indexFile: indexFile,
+		entries:   make(map[string]*chainDBEntry),
+		txIndex:   make(map[string]TxLocation),
+	}
+	if err := db.load(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// load reads the index journal and rebuilds the main chain indexes from the
+// stored tip. A record cut short by a crash ends the journal; both files are
+// truncated after the last complete record, so new records are not appended
+// behind the broken one.
+func (db *ChainDB) load() error {
+	info, err := db.dataFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read block data: %v", err)
+	}
+
+	var indexEnd, dataEnd int64
+	reader := bufio.NewReader(db.indexFile)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read block index: %v", err)
+		}
+		var entry chainDBEntry
+		if err := json.Unmarshal(line, &entry); err != nil || entry.Offset+entry.Length > info.Size() {
+			break
+		}
+		indexEnd += int64(len(line))
+		if end := entry.Offset + entry.Length + 1; end > dataEnd {
+			dataEnd = end
+		}
+		if _, exists := db.entries[entry.Hash]; !exists {
+			db.entries[entry.Hash] = &entry
+			db.order = append(db.order, &entry)
+		}
+	}
+
+	// The newline ending the last block may be missing
+	if dataEnd > info.Size() {
+		dataEnd = info.Size()
+	}
+	if err := db.indexFile.Truncate(indexEnd); err != nil {
+		return fmt.Errorf("failed to truncate block index: %v", err)
+	}
+	if err := db.dataFile.Truncate(dataEnd); err != nil {
+		return fmt.Errorf("failed to truncate block data: %v", err)
+	}
+
+	tip, err := os.ReadFile(filepath.Join(db.dir, chainDBTipFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read chain tip: %v", err)
+	}
+	if _, exists := db.entries[strings.TrimSpace(string(tip))]; !exists {
+		return nil
+	}
+	return db.setTip(strings.TrimSpace(string(tip)))
+}
+
+// Close closes the database files
+func (db *ChainDB) Close() error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	dataErr := db.dataFile.Close()
+	indexErr := db.indexFile.Close()
+	if dataErr != nil {
+		return dataErr
+	}
+	return indexErr
+}
+
+// PutBlock appends a block to the database. Storing a block twice is a no-op.
+func (db *ChainDB) PutBlock(block *Block) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, exists := db.entries[block.Hash]; exists {
+		return nil
+	}
+
+	data, err := json.Marshal(block)
+	if err != nil {
+		return fmt.Errorf("failed to serialize block: %v", err)
+	}
+	offset, err := db.dataFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to append block: %v", err)
+	}
+	if _, err := db.dataFile.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to append block: %v", err)
+	}
+	if err := db.dataFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block data: %v", err)
+	}
+
+	entry := &chainDBEntry{
+		Hash:     block.Hash,
+		PrevHash: block.PrevHash,
+		Height:   block.Index,
+		Offset:   offset,
+		Length:   int64(len(data)),
+	}
+	line, _ := json.Marshal(entry)
+	if _, err := db.indexFile.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to index block: %v", err)
+	}
+	if err := db.indexFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block index: %v", err)
+	}
+
+	db.entries[block.Hash] = entry
+	db.order = append(db.order, entry)
+	return nil
+}
+
+// SetTip records a stored block as the main chain tip and updates the height
+// and transaction indexes
+func (db *ChainDB) SetTip(hash string) error {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	if _, exists := db.entries[hash]; !exists {
+		return fmt.Errorf("tip block %s: %w", hash, ErrNotFound)
+	}
+
+	// Write the new tip to a temporary file and rename it over the old one so
+	// a crash leaves either tip in place. The file is synced before the
+	// rename and the directory after it, so the rename never exposes an
+	// unwritten file and is not itself lost.
+	tipPath := filepath.Join(db.dir, chainDBTipFile)
+	if err := writeFileSync(tipPath+".tmp", []byte(hash+"\n")); err != nil {
+		return fmt.Errorf("failed to write chain tip: %v", err)
+	}
+	if err := os.Rename(tipPath+".tmp", tipPath); err != nil {
+		return fmt.Errorf("failed to write chain tip: %v", err)
+	}
+	if err := syncDir(db.dir); err != nil {
+		return fmt.Errorf("failed to sync chain database directory: %v", err)
+	}
+	return db.setTip(hash)
+}
+
+// writeFileSync writes data to a file and syncs it to disk
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// syncDir syncs a directory, making the renames in it durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// setTip moves the main chain indexes to the branch ending at hash, touching
+// only the blocks after the fork point. The caller must hold the write lock.
+func (db *ChainDB) setTip(hash string) error {
+	var connected []*chainDBEntry
+	entry := db.entries[hash]
+	for entry != nil && (entry.Height >= int64(len(db.heights)) || db.heights[entry.Height] != entry.Hash) {
+		connected = append(connected, entry)
+		entry = db.entries[entry.PrevHash]
+	}
+	forkHeight := int64(-1)
+	if entry != nil {
+		forkHeight = entry.Height
+	}
+
+	for height := int64(len(db.heights)) - 1; height > forkHeight; height-- {
+		block, err := db.readBlock(db.entries[db.heights[height]])
+		if err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			delete(db.txIndex, tx.Hash())
+		}
+	}
+	db.heights = db.heights[:forkHeight+1]
+
+	for i := len(connected) - 1; i >= 0; i-- {
+		block, err := db.readBlock(connected[i])
+		if err != nil {
+			return err
+		}
+		for position, tx := range block.Transactions {
+			db.txIndex[tx.Hash()] = TxLocation{
+				BlockHash: block.Hash,
+				Height:    block.Index,
+				Position:  position,
+			}
+		}
+		db.heights = append(db.heights, block.Hash)
+	}
+	return nil
+}
+
+// readBlock reads a block record from the data file
+func (db *ChainDB) readBlock(entry *chainDBEntry) (*Block, error) {
+	data := make([]byte, entry.Length)
+	if _, err := db.dataFile.ReadAt(data, entry.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read block %s: %v", entry.Hash, err)
+	}
+
+	var block Block
+	if err := json.Unmarshal(data, &block); err != nil {
+		return nil, fmt.Errorf("failed to decode block %s: %v", entry.Hash, err)
+	}
+	return &block, nil
+}
+
+// Tip returns the hash of the main chain tip, or "" if none is recorded
+func (db *ChainDB) Tip() string {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if len(db.heights) == 0 {
+		return ""
+	}
+	return db.heights[len(db.heights)-1]
+}
+
+// Height returns the height of the main chain tip, or -1 if none is recorded
+func (db *ChainDB) Height() int64 {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+	return int64(len(db.heights)) - 1
+}
+
+// BlockByHash returns a stored block, on the main chain or not
+func (db *ChainDB) BlockByHash(hash string) (*Block, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	entry, exists := db.entries[hash]
+	if !exists {
+		return nil, fmt.Errorf("block %s: %w", hash, ErrNotFound)
+	}
+	return db.readBlock(entry)
+}
+
+// BlockByHeight returns the main chain block at a height
+func (db *ChainDB) BlockByHeight(height int64) (*Block, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	if height < 0 || height >= int64(len(db.heights)) {
+		return nil, fmt.Errorf("block at height %d: %w", height, ErrNotFound)
+	}
+	return db.readBlock(db.entries[db.heights[height]])
+}
+
+// Transaction returns a main chain transaction by ID along with its location
+func (db *ChainDB) Transaction(txID string) (*Transaction, TxLocation, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	location, exists := db.txIndex[txID]
+	if !exists {
+		return nil, TxLocation{}, fmt.Errorf("transaction %s: %w", txID, ErrNotFound)
+	}
+	block, err := db.readBlock(db.entries[location.BlockHash])
+	if err != nil {
+		return nil, TxLocation{}, err
+	}
+	return &block.Transactions[location.Position], location, nil
+}
+
+// Blocks returns every stored block in storage order, parents before children
+func (db *ChainDB) Blocks() ([]*Block, error) {
+	db.mutex.RLock()
+	defer db.mutex.RUnlock()
+
+	blocks := make([]*Block, 0, len(db.order))
+	for _, entry := range db.order {
+		block, err := db.readBlock(entry)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
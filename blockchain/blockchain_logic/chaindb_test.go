@@ -0,0 +1,59 @@
+// chaindb_test.go
+package blockchain_logic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestChainDBTornIndexRecord checks that a block stored after a crash cut an
+// index record short is still found once the database is reopened again
+func TestChainDBTornIndexRecord(t *testing.T) {
+	dir := t.TempDir()
+	first := &Block{BlockHeader: BlockHeader{Index: 0, Hash: "first"}}
+	second := &Block{BlockHeader: BlockHeader{Index: 1, Hash: "second", PrevHash: "first"}}
+
+	db, err := OpenChainDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutBlock(first); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Simulate a crash in the middle of writing the next index record
+	index, err := os.OpenFile(filepath.Join(dir, chainDBIndexFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.WriteString(`{"hash":"torn","prev_h`); err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+
+	db, err = OpenChainDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutBlock(second); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = OpenChainDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, block := range []*Block{first, second} {
+		stored, err := db.BlockByHash(block.Hash)
+		if err != nil {
+			t.Fatalf("block %s lost after reopening: %v", block.Hash, err)
+		}
+		if stored.PrevHash != block.PrevHash || stored.Index != block.Index {
+			t.Fatalf("block %s read back as %+v", block.Hash, stored.BlockHeader)
+		}
+	}
+}
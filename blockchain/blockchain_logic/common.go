@@ -1,30 +1,33 @@
 package blockchain_logic
 
 import (
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"strconv"
 )
 
-// Transaction represents a single transaction in the system
+// Transaction represents a single transaction in the system. Sender is the
+// address derived from PublicKey, and Signature covers SigningBytes. Nonce
+// counts the sender's previous transactions and prevents replays.
 type Transaction struct {
 	Sender    string  `json:"sender"`
 	Receiver  string  `json:"receiver"`
 	Amount    float64 `json:"amount"`
 	Timestamp int64   `json:"timestamp"`
+	Nonce     uint64  `json:"nonce"`
+	PublicKey string  `json:"public_key,omitempty"`
+	Signature string  `json:"signature,omitempty"`
 }
 
-// TransactionPool manages the collection of transactions
-type TransactionPool struct {
-	Transactions []Transaction
-}
-
-// NewTransactionPool creates a new transaction pool
-func NewTransactionPool() *TransactionPool {
-	return &TransactionPool{
-		Transactions: make([]Transaction, 0),
-	}
+// Hash returns the transaction ID, the hash of its signed fields and signature
+func (tx *Transaction) Hash() string {
+	hash := sha256.New()
+	hash.Write(tx.SigningBytes())
+	hash.Write([]byte(tx.Signature))
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 // ReadTransactionsFromCSV reads and validates transactions from CSV
@@ -43,7 +46,9 @@ func ReadTransactionsFromCSV(filepath string) ([]Transaction, error) {
 		return nil, fmt.Errorf("error reading header: %v", err)
 	}
 
-	if len(header) != 3 || header[0] != "Sender" || header[1] != "Receiver" || header[2] != "Amount" {
+	// An optional fourth Label column is only used for training
+	if len(header) < 3 || len(header) > 4 || header[0] != "Sender" || header[1] != "Receiver" || header[2] != "Amount" ||
+		(len(header) == 4 && header[3] != "Label") {
 		return nil, fmt.Errorf("invalid CSV header format")
 	}
 
@@ -58,7 +63,7 @@ func ReadTransactionsFromCSV(filepath string) ([]Transaction, error) {
 		}
 		lineNum++
 
-		if len(record) != 3 {
+		if len(record) != len(header) {
 			return nil, fmt.Errorf("invalid record at line %d", lineNum)
 		}
 
@@ -0,0 +1,119 @@
+// difficulty.go
+package blockchain_logic
+
+import (
+	"encoding/hex"
+	"math/big"
+	"time"
+)
+
+const (
+	// DefaultDifficulty needs about as much work as four leading zero hex digits
+	DefaultDifficulty uint64 = 1 << 16
+	// DefaultTargetBlockTime is the block interval retargeting aims for
+	DefaultTargetBlockTime = 10 * time.Second
+	// DefaultRetargetInterval is the number of blocks between retargets
+	DefaultRetargetInterval int64 = 10
+	// maxRetargetFactor bounds how far a single retarget can move difficulty
+	maxRetargetFactor = 4
+	// MaxFutureBlockTime is how far ahead of local time a block may be dated
+	MaxFutureBlockTime = 2 * time.Hour
+)
+
+// maxTarget is the easiest possible target, 2^256 - 1
+var maxTarget = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// ConsensusParams are the proof-of-work rules every node must agree on
+type ConsensusParams struct {
+	InitialDifficulty uint64
+	TargetBlockTime   time.Duration
+	RetargetInterval  int64
+}
+
+// withDefaults fills in unset parameters
+func (cp ConsensusParams) withDefaults() ConsensusParams {
+	if cp.InitialDifficulty == 0 {
+		cp.InitialDifficulty = DefaultDifficulty
+	}
+	if cp.TargetBlockTime < time.Second {
+		cp.TargetBlockTime = DefaultTargetBlockTime
+	}
+	if cp.RetargetInterval < 2 {
+		cp.RetargetInterval = DefaultRetargetInterval
+	}
+	return cp
+}
+
+// DifficultyToTarget converts a difficulty into the 256-bit target a block
+// hash must not exceed
+func DifficultyToTarget(difficulty uint64) *big.Int {
+	if difficulty == 0 {
+		difficulty = 1
+	}
+	return new(big.Int).Div(maxTarget, new(big.Int).SetUint64(difficulty))
+}
+
+// Target returns the 256-bit proof-of-work target of the header
+func (h *BlockHeader) Target() *big.Int {
+	return DifficultyToTarget(h.Difficulty)
+}
+
+// Work returns the expected number of hashes needed to meet the header's
+// target, 2^256 / (target + 1)
+func (h *BlockHeader) Work() *big.Int {
+	denominator := new(big.Int).Add(h.Target(), big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// meetsTarget reports whether a hex encoded hash is at or below the target
+func meetsTarget(hash string, target *big.Int) bool {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil || len(hashBytes) != 32 {
+		return false
+	}
+	return new(big.Int).SetBytes(hashBytes).Cmp(target) <= 0
+}
+
+// NextDifficulty computes the difficulty required for the block following
+// the given chain. Every RetargetInterval blocks the difficulty is scaled by
+// how much faster or slower than TargetBlockTime the last interval was mined,
+// limited to a factor of four. The genesis block is left out of the window
+// because its timestamp is fixed.
+func (cp ConsensusParams) NextDifficulty(chain []*Block) uint64 {
+	cp = cp.withDefaults()
+	if len(chain) == 0 {
+		return cp.InitialDifficulty
+	}
+
+	height := int64(len(chain))
+	parent := chain[height-1]
+	if height%cp.RetargetInterval != 0 || height-cp.RetargetInterval < 1 {
+		return parent.Difficulty
+	}
+
+	first := chain[height-cp.RetargetInterval]
+	expectedSpan := int64(cp.TargetBlockTime/time.Second) * (cp.RetargetInterval - 1)
+	actualSpan := parent.Timestamp - first.Timestamp
+
+	if actualSpan < expectedSpan/maxRetargetFactor {
+		actualSpan = expectedSpan / maxRetargetFactor
+	}
+	if actualSpan > expectedSpan*maxRetargetFactor {
+		actualSpan = expectedSpan * maxRetargetFactor
+	}
+	if actualSpan < 1 {
+		actualSpan = 1
+	}
+
+	next := new(big.Int).SetUint64(parent.Difficulty)
+	next.Mul(next, big.NewInt(expectedSpan))
+	next.Div(next, big.NewInt(actualSpan))
+
+	if next.Sign() <= 0 {
+		return 1
+	}
+	if !next.IsUint64() {
+		return ^uint64(0)
+	}
+	return next.Uint64()
+}
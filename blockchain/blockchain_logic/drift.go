@@ -0,0 +1,270 @@
+// drift.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultDriftPSIThreshold is the population stability index above which
+	// a feature is considered to have drifted. Values above 0.2 are commonly
+	// read as a significant shift.
+	DefaultDriftPSIThreshold = 0.2
+	// DefaultDriftKSAlpha is the significance level of the Kolmogorov-Smirnov
+	// test
+	DefaultDriftKSAlpha = 0.05
+	// driftBins is the number of reference quantile bins used for the PSI
+	driftBins = 10
+	// driftEpsilon stands in for empty bins, whose share would make the PSI
+	// infinite
+	driftEpsilon = 1e-4
+)
+
+// DriftConfig holds the settings of a DriftMonitor
+type DriftConfig struct {
+	// Window is the number of recent transactions compared with the
+	// training data
+	Window int
+	// EvaluateEvery is the number of transactions between evaluations.
+	// Defaults to a tenth of the window.
+	EvaluateEvery int
+	// PSIThreshold defaults to DefaultDriftPSIThreshold
+	PSIThreshold float64
+	// KSAlpha defaults to DefaultDriftKSAlpha
+	KSAlpha float64
+}
+
+// FeatureDrift compares the live distribution of one feature with the
+// training distribution
+type FeatureDrift struct {
+	Feature     string  `json:"feature"`
+	PSI         float64 `json:"psi"`
+	KS          float64 `json:"ks"`           // Largest distance between the two CDFs
+	KSCritical  float64 `json:"ks_critical"`  // KS statistic at which the test rejects at KSAlpha
+	Drifted     bool    `json:"drifted"`      // PSI or KS crossed its threshold
+	WindowCount int     `json:"window_count"` // Live transactions compared
+}
+
+// DriftEvent is emitted when a feature starts or stops drifting
+type DriftEvent struct {
+	FeatureDrift
+	Observed int // Transactions observed when the change was detected
+}
+
+// driftSample is a live transaction in the drift window
+type driftSample struct {
+	txID     string
+	features []float64
+}
+
+// DriftMonitor compares the features of live transactions, over a sliding
+// window, with the features of the training data, and reports the population
+// stability index and the Kolmogorov-Smirnov statistic of each feature
+type DriftMonitor struct {
+	config    DriftConfig
+	extract   func(Transaction) []float64
+	reference [][]float64   // Sorted training values of each feature
+	edges     [][]float64   // Inner PSI bin edges of each feature
+	expected  [][]float64   // Training share of each PSI bin
+	window    []driftSample // Ring buffer of live transactions
+	inWindow  map[string]bool
+	next      int
+	observed  int
+	report    []FeatureDrift
+	listeners []func(DriftEvent)
+	mutex     sync.Mutex
+}
+
+// NewDriftMonitor creates a monitor for the features a validator extracts,
+// with the feature values of its training data, kept with the model, as the
+// reference distribution
+func NewDriftMonitor(validator TransactionValidator, config DriftConfig) (*DriftMonitor, error) {
+	if config.Window <= 1 {
+		return nil, fmt.Errorf("drift window must hold at least 2 transactions")
+	}
+	reference := validator.ReferenceFeatures()
+	if reference == nil {
+		return nil, fmt.Errorf("model has no reference feature values; save it again after training")
+	}
+	if len(reference[0]) < 2 {
+		return nil, fmt.Errorf("drift monitor needs at least 2 training transactions")
+	}
+	if config.EvaluateEvery <= 0 {
+		config.EvaluateEvery = config.Window / 10
+		if config.EvaluateEvery == 0 {
+			config.EvaluateEvery = 1
+		}
+	}
+	if config.PSIThreshold <= 0 {
+		config.PSIThreshold = DefaultDriftPSIThreshold
+	}
+	if config.KSAlpha <= 0 {
+		config.KSAlpha = DefaultDriftKSAlpha
+	}
+
+	dm := &DriftMonitor{
+		config:    config,
+		extract:   validator.Features,
+		reference: make([][]float64, numFeatures),
+		edges:     make([][]float64, numFeatures),
+		expected:  make([][]float64, numFeatures),
+		inWindow:  make(map[string]bool),
+	}
+	for i, values := range reference {
+		values = append([]float64(nil), values...)
+		sort.Float64s(values)
+		dm.reference[i] = values
+		dm.edges[i] = quantileEdges(values, driftBins)
+		dm.expected[i] = binShares(values, dm.edges[i])
+	}
+	return dm, nil
+}
+
+// OnDrift registers a function that is called when a feature starts or stops
+// drifting. It runs without the monitor lock held.
+func (dm *DriftMonitor) OnDrift(listener func(DriftEvent)) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	dm.listeners = append(dm.listeners, listener)
+}
+
+// Observe adds a live transaction to the window and re-evaluates drift every
+// EvaluateEvery transactions once the window is full. Transactions already
+// in the window, such as pending ones validated again, are ignored.
+func (dm *DriftMonitor) Observe(tx Transaction) {
+	sample := driftSample{txID: tx.Hash(), features: dm.extract(tx)}
+
+	dm.mutex.Lock()
+	if dm.inWindow[sample.txID] {
+		dm.mutex.Unlock()
+		return
+	}
+	if len(dm.window) < dm.config.Window {
+		dm.window = append(dm.window, sample)
+	} else {
+		delete(dm.inWindow, dm.window[dm.next].txID)
+		dm.window[dm.next] = sample
+		dm.next = (dm.next + 1) % dm.config.Window
+	}
+	dm.inWindow[sample.txID] = true
+	dm.observed++
+
+	var events []DriftEvent
+	if len(dm.window) == dm.config.Window && dm.observed%dm.config.EvaluateEvery == 0 {
+		events = dm.evaluate()
+	}
+	listeners := dm.listeners
+	dm.mutex.Unlock()
+
+	for _, event := range events {
+		for _, listener := range listeners {
+			listener(event)
+		}
+	}
+}
+
+// evaluate recomputes the drift of every feature and returns an event for
+// each feature whose state changed. The caller must hold the lock.
+func (dm *DriftMonitor) evaluate() []DriftEvent {
+	n, m := float64(len(dm.window)), float64(len(dm.reference[0]))
+	critical := math.Sqrt(-math.Log(dm.config.KSAlpha/2)/2) * math.Sqrt((n+m)/(n*m))
+
+	var events []DriftEvent
+	report := make([]FeatureDrift, numFeatures)
+	for i := range report {
+		live := make([]float64, len(dm.window))
+		for j, sample := range dm.window {
+			live[j] = sample.features[i]
+		}
+		sort.Float64s(live)
+
+		drift := FeatureDrift{
+			Feature:     featureNames[i],
+			PSI:         psi(dm.expected[i], binShares(live, dm.edges[i])),
+			KS:          ksStatistic(dm.reference[i], live),
+			KSCritical:  critical,
+			WindowCount: len(live),
+		}
+		drift.Drifted = drift.PSI > dm.config.PSIThreshold || drift.KS > critical
+		report[i] = drift
+
+		wasDrifted := dm.report != nil && dm.report[i].Drifted
+		if drift.Drifted != wasDrifted {
+			events = append(events, DriftEvent{FeatureDrift: drift, Observed: dm.observed})
+			if drift.Drifted {
+				fmt.Printf("Drift detected in feature %s: PSI %.4f, KS %.4f (critical %.4f)\n", drift.Feature, drift.PSI, drift.KS, critical)
+			} else {
+				fmt.Printf("Feature %s no longer drifting: PSI %.4f, KS %.4f\n", drift.Feature, drift.PSI, drift.KS)
+			}
+		}
+	}
+	dm.report = report
+	return events
+}
+
+// Report returns the drift of each feature at the last evaluation, or nil
+// before the window first filled up
+func (dm *DriftMonitor) Report() []FeatureDrift {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	return append([]FeatureDrift(nil), dm.report...)
+}
+
+// quantileEdges returns the distinct inner edges splitting sorted values
+// into bins of roughly equal count
+func quantileEdges(sorted []float64, bins int) []float64 {
+	var edges []float64
+	for b := 1; b < bins; b++ {
+		edge := sorted[b*len(sorted)/bins]
+		if len(edges) == 0 || edge > edges[len(edges)-1] {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// binShares returns the share of values in each bin. Bin k holds the values
+// from edges[k-1] up to but excluding edges[k].
+func binShares(values, edges []float64) []float64 {
+	shares := make([]float64, len(edges)+1)
+	for _, value := range values {
+		shares[sort.Search(len(edges), func(k int) bool { return edges[k] > value })]++
+	}
+	for k := range shares {
+		shares[k] /= float64(len(values))
+	}
+	return shares
+}
+
+// psi is the population stability index of actual shares against expected
+// shares: sum((actual - expected) * ln(actual / expected))
+func psi(expected, actual []float64) float64 {
+	var sum float64
+	for k := range expected {
+		e := math.Max(expected[k], driftEpsilon)
+		a := math.Max(actual[k], driftEpsilon)
+		sum += (a - e) * math.Log(a/e)
+	}
+	return sum
+}
+
+// ksStatistic is the two-sample Kolmogorov-Smirnov statistic of two sorted
+// samples: the largest distance between their empirical CDFs
+func ksStatistic(a, b []float64) float64 {
+	var i, j int
+	var d float64
+	for i < len(a) && j < len(b) {
+		x := math.Min(a[i], b[j])
+		for i < len(a) && a[i] <= x {
+			i++
+		}
+		for j < len(b) && b[j] <= x {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/float64(len(a))-float64(j)/float64(len(b))))
+	}
+	return d
+}
@@ -0,0 +1,204 @@
+// features.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// numFeatures is the number of features extracted from a transaction
+const numFeatures = 5
+
+// featureNames names the features in the order extractFeatures returns them
+var featureNames = [numFeatures]string{
+	"amount_zscore",
+	"sender_frequency",
+	"receiver_frequency",
+	"sender_avg_diff",
+	"receiver_avg_diff",
+}
+
+// featureStats are the training data statistics that features are computed
+// against. They are shared by every validator model.
+type featureStats struct {
+	senderCounts   map[string]int
+	receiverCounts map[string]int
+	meanAmount     float64
+	stdAmount      float64
+	maxAmount      float64
+	minAmount      float64
+	// New fields for pattern recognition
+	senderAverages   map[string]float64
+	receiverAverages map[string]float64
+	count            int // Transactions the statistics cover
+	// reference holds the sorted training values of each feature, the
+	// distribution drift is measured against. It is saved with the model but
+	// not hashed, and online learning leaves it unchanged.
+	reference [][]float64
+}
+
+// newFeatureStats creates empty statistics
+func newFeatureStats() featureStats {
+	return featureStats{
+		senderCounts:     make(map[string]int),
+		receiverCounts:   make(map[string]int),
+		senderAverages:   make(map[string]float64),
+		receiverAverages: make(map[string]float64),
+	}
+}
+
+// fit collects the statistics of the training samples
+func (fs *featureStats) fit(samples []trainingSample) {
+	*fs = newFeatureStats()
+
+	// First pass: collect statistics
+	var sumAmount float64
+	var amounts []float64
+	senderTotals := make(map[string]float64)
+	receiverTotals := make(map[string]float64)
+
+	fs.maxAmount = 0
+	fs.minAmount = math.MaxFloat64
+
+	for _, sample := range samples {
+		amount := sample.amount
+		sender, receiver := sample.sender, sample.receiver
+
+		// Update statistics
+		sumAmount += amount
+		amounts = append(amounts, amount)
+		fs.senderCounts[sender]++
+		fs.receiverCounts[receiver]++
+		senderTotals[sender] += amount
+		receiverTotals[receiver] += amount
+
+		// Update min/max
+		if amount > fs.maxAmount {
+			fs.maxAmount = amount
+		}
+		if amount < fs.minAmount {
+			fs.minAmount = amount
+		}
+	}
+
+	// Calculate averages
+	for sender, total := range senderTotals {
+		fs.senderAverages[sender] = total / float64(fs.senderCounts[sender])
+	}
+	for receiver, total := range receiverTotals {
+		fs.receiverAverages[receiver] = total / float64(fs.receiverCounts[receiver])
+	}
+
+	// Calculate mean and standard deviation
+	fs.meanAmount = sumAmount / float64(len(samples))
+	var sumSquares float64
+	for _, amount := range amounts {
+		diff := amount - fs.meanAmount
+		sumSquares += float64(diff * diff)
+	}
+	fs.stdAmount = math.Sqrt(sumSquares / float64(len(amounts)))
+	fs.count = len(samples)
+
+	fs.reference = make([][]float64, numFeatures)
+	for _, sample := range samples {
+		for i, value := range fs.extractFeatures(sample.sender, sample.receiver, sample.amount) {
+			fs.reference[i] = append(fs.reference[i], value)
+		}
+	}
+	for _, values := range fs.reference {
+		sort.Float64s(values)
+	}
+
+	fmt.Printf("\nModel Training Statistics:\n")
+	fmt.Printf("Number of transactions: %d\n", len(samples))
+	fmt.Printf("Average amount: %.2f\n", fs.meanAmount)
+	fmt.Printf("Standard deviation: %.2f\n", fs.stdAmount)
+	fmt.Printf("Min amount: %.2f\n", fs.minAmount)
+	fmt.Printf("Max amount: %.2f\n", fs.maxAmount)
+	fmt.Printf("Unique senders: %d\n", len(fs.senderCounts))
+	fmt.Printf("Unique receivers: %d\n", len(fs.receiverCounts))
+}
+
+// add updates the statistics with one more transaction, using Welford's
+// method for the amount mean and standard deviation. Products are rounded
+// explicitly with float64() so that no platform fuses them into
+// multiply-adds, and every node computes the same statistics.
+func (fs *featureStats) add(sender, receiver string, amount float64) {
+	n := float64(fs.count)
+	variance := float64(fs.stdAmount * fs.stdAmount)
+	delta := amount - fs.meanAmount
+	fs.meanAmount += delta / (n + 1)
+	fs.stdAmount = math.Sqrt((float64(variance*n) + float64(delta*(amount-fs.meanAmount))) / (n + 1))
+	fs.count++
+
+	fs.senderCounts[sender]++
+	fs.senderAverages[sender] += (amount - fs.senderAverages[sender]) / float64(fs.senderCounts[sender])
+	fs.receiverCounts[receiver]++
+	fs.receiverAverages[receiver] += (amount - fs.receiverAverages[receiver]) / float64(fs.receiverCounts[receiver])
+
+	if amount > fs.maxAmount {
+		fs.maxAmount = amount
+	}
+	if amount < fs.minAmount {
+		fs.minAmount = amount
+	}
+}
+
+// clone returns an independent copy of the statistics
+func (fs *featureStats) clone() featureStats {
+	clone := *fs
+	clone.senderCounts = make(map[string]int, len(fs.senderCounts))
+	clone.receiverCounts = make(map[string]int, len(fs.receiverCounts))
+	clone.senderAverages = make(map[string]float64, len(fs.senderAverages))
+	clone.receiverAverages = make(map[string]float64, len(fs.receiverAverages))
+	for addr, count := range fs.senderCounts {
+		clone.senderCounts[addr] = count
+	}
+	for addr, count := range fs.receiverCounts {
+		clone.receiverCounts[addr] = count
+	}
+	for addr, average := range fs.senderAverages {
+		clone.senderAverages[addr] = average
+	}
+	for addr, average := range fs.receiverAverages {
+		clone.receiverAverages[addr] = average
+	}
+	return clone
+}
+
+// ReferenceFeatures returns the sorted training values of each feature
+func (fs *featureStats) ReferenceFeatures() [][]float64 {
+	return fs.reference
+}
+
+// Features returns the model inputs for a transaction, in the order of
+// featureNames
+func (fs *featureStats) Features(tx Transaction) []float64 {
+	return fs.extractFeatures(tx.Sender, tx.Receiver, tx.Amount)
+}
+
+func (fs *featureStats) extractFeatures(sender, receiver string, amount float64) []float64 {
+	// Feature 1: Normalized amount
+	normalizedAmount := (amount - fs.meanAmount) / fs.stdAmount
+
+	// Feature 2: Sender frequency (normalized)
+	senderFreq := float64(fs.senderCounts[sender]) / float64(len(fs.senderCounts))
+
+	// Feature 3: Receiver frequency (normalized)
+	receiverFreq := float64(fs.receiverCounts[receiver]) / float64(len(fs.receiverCounts))
+
+	// Feature 4: Sender's average transaction difference
+	senderAvgDiff := math.Abs(amount-fs.senderAverages[sender]) / fs.maxAmount
+
+	// Feature 5: Receiver's average transaction difference
+	receiverAvgDiff := math.Abs(amount-fs.receiverAverages[receiver]) / fs.maxAmount
+
+	return []float64{
+		normalizedAmount,
+		senderFreq,
+		receiverFreq,
+		senderAvgDiff,
+		receiverAvgDiff,
+	}
+}
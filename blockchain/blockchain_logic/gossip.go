@@ -0,0 +1,86 @@
+// gossip.go
+package blockchain_logic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGossipTTL is the number of hops a gossiped message may travel
+	DefaultGossipTTL = 6
+	// seenCacheCapacity bounds the number of remembered message IDs
+	seenCacheCapacity = 10000
+	// seenCacheExpiry is how long a message ID is remembered
+	seenCacheExpiry = 10 * time.Minute
+)
+
+// ComputeMessageID derives a gossip message ID from the message type, its
+// origin and the hash of its content
+func ComputeMessageID(messageType MessageType, origin string, content interface{}) string {
+	contentData, _ := json.Marshal(content)
+	contentHash := sha256.Sum256(contentData)
+
+	hash := sha256.New()
+	hash.Write([]byte(messageType))
+	hash.Write([]byte{0})
+	hash.Write([]byte(origin))
+	hash.Write([]byte{0})
+	hash.Write(contentHash[:])
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// seenCache remembers recently seen message IDs so that flooded messages are
+// processed and forwarded only once
+type seenCache struct {
+	seenAt   map[string]time.Time
+	order    []string // IDs in the order they were first seen
+	capacity int
+	expiry   time.Duration
+	mutex    sync.Mutex
+}
+
+func newSeenCache(capacity int, expiry time.Duration) *seenCache {
+	return &seenCache{
+		seenAt:   make(map[string]time.Time),
+		capacity: capacity,
+		expiry:   expiry,
+	}
+}
+
+// MarkSeen records the ID and reports whether it was not seen before
+func (sc *seenCache) MarkSeen(id string) bool {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+
+	now := time.Now()
+	sc.evict(now)
+
+	if _, seen := sc.seenAt[id]; seen {
+		return false
+	}
+
+	sc.seenAt[id] = now
+	sc.order = append(sc.order, id)
+	return true
+}
+
+// evict drops expired entries and the oldest entries beyond capacity
+func (sc *seenCache) evict(now time.Time) {
+	drop := 0
+	for drop < len(sc.order) {
+		id := sc.order[drop]
+		expired := now.Sub(sc.seenAt[id]) > sc.expiry
+		overCapacity := len(sc.order)-drop >= sc.capacity
+		if !expired && !overCapacity {
+			break
+		}
+		delete(sc.seenAt, id)
+		drop++
+	}
+
+	sc.order = sc.order[drop:]
+}
@@ -0,0 +1,155 @@
+// handshake.go
+package blockchain_logic
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// ProtocolVersion is the version of the peer protocol spoken by this
+	// node, and the newest one we accept
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest peer protocol version we accept
+	MinProtocolVersion = 1
+	// handshakeTimeout bounds how long we wait for the peer's HELLO
+	handshakeTimeout = 10 * time.Second
+)
+
+// HelloPayload is the first message exchanged on every connection
+type HelloPayload struct {
+	Version     int    `json:"version"`
+	NodeID      string `json:"node_id"`
+	ListenAddr  string `json:"listen_addr"`
+	GenesisHash string `json:"genesis_hash"`
+	BestHeight  int    `json:"best_height"`
+}
+
+// newNodeID generates a random identifier for this node
+func newNodeID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("failed to generate node ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// localHello builds the HELLO payload describing this node
+func (pn *PeerNetwork) localHello() HelloPayload {
+	hello := HelloPayload{
+		Version:    ProtocolVersion,
+		NodeID:     pn.NodeID,
+		ListenAddr: pn.MyAddress,
+		BestHeight: -1,
+	}
+	if pn.blockchain != nil {
+		hello.GenesisHash = pn.blockchain.GenesisHash()
+		hello.BestHeight = pn.blockchain.Height()
+	}
+	return hello
+}
+
+// checkHello validates the HELLO payload received from a peer
+func (pn *PeerNetwork) checkHello(hello *HelloPayload) error {
+	if hello.Version < MinProtocolVersion || hello.Version > ProtocolVersion {
+		return fmt.Errorf("incompatible protocol version %d (supported %d to %d)", hello.Version, MinProtocolVersion, ProtocolVersion)
+	}
+	if hello.NodeID == "" {
+		return fmt.Errorf("missing node ID")
+	}
+	if hello.NodeID == pn.NodeID {
+		return fmt.Errorf("connected to self")
+	}
+	if hello.GenesisHash != pn.localHello().GenesisHash {
+		return fmt.Errorf("different genesis block %s", hello.GenesisHash)
+	}
+	return nil
+}
+
+// handshake exchanges HELLO messages with a new peer, filling in its
+// description, and returns the decoder to keep reading from. It runs before
+// the peer's writer is started, so HELLO is written to the connection
+// directly.
+func (pn *PeerNetwork) handshake(peer *PeerConnection) (*json.Decoder, error) {
+	hello := BlockchainMessage{
+		Type:    MessageTypeHello,
+		Content: pn.localHello(),
+		From:    pn.MyAddress,
+	}
+	conn := peer.Conn
+	conn.SetWriteDeadline(time.Now().Add(handshakeTimeout))
+	if err := json.NewEncoder(conn).Encode(hello); err != nil {
+		return nil, fmt.Errorf("failed to send HELLO: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	decoder := json.NewDecoder(conn)
+	var message BlockchainMessage
+	if err := decoder.Decode(&message); err != nil {
+		return nil, fmt.Errorf("failed to read HELLO: %v", err)
+	}
+	if message.Type != MessageTypeHello {
+		return nil, fmt.Errorf("expected HELLO, got %s", message.Type)
+	}
+
+	remote := message.Content.(*HelloPayload)
+	if err := pn.checkHello(remote); err != nil {
+		return nil, err
+	}
+
+	peer.Address = remote.ListenAddr
+	peer.NodeID = remote.NodeID
+	peer.Version = remote.Version
+	peer.BestHeight = remote.BestHeight
+	return decoder, nil
+}
+
+// preferConnection reports whether a connection to the peer should win over
+// another connection to the same node. Both ends keep the connection dialed
+// by the node with the smaller ID, so simultaneous dials resolve the same way
+// on each side.
+func (pn *PeerNetwork) preferConnection(peer *PeerConnection) bool {
+	dialer := peer.NodeID
+	if peer.Outbound {
+		dialer = pn.NodeID
+	}
+	lowest := pn.NodeID
+	if peer.NodeID < lowest {
+		lowest = peer.NodeID
+	}
+	return dialer == lowest
+}
+
+// registerPeer adds a handshaken peer, deduplicating connections by node ID.
+// It returns false when the new connection is redundant and should be closed.
+func (pn *PeerNetwork) registerPeer(peer *PeerConnection) bool {
+	pn.mutex.Lock()
+	defer pn.mutex.Unlock()
+
+	if existing, exists := pn.Peers[peer.NodeID]; exists {
+		if !pn.preferConnection(peer) || pn.preferConnection(existing) {
+			return false
+		}
+		// The new connection wins; its handler cleanup will not remove the
+		// replacement since the map no longer points at the old connection
+		existing.Close()
+	}
+
+	pn.Peers[peer.NodeID] = peer
+	return true
+}
+
+// unregisterPeer removes the peer if the map still refers to this connection
+func (pn *PeerNetwork) unregisterPeer(peer *PeerConnection) {
+	pn.mutex.Lock()
+	defer pn.mutex.Unlock()
+
+	if pn.Peers[peer.NodeID] == peer {
+		delete(pn.Peers, peer.NodeID)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	shell "github.com/ipfs/go-ipfs-api"
 )
 
+// IPFSHandler is the BlockStore backed by a Kubo (IPFS) node
 type IPFSHandler struct {
 	shell *shell.Shell
 	ctx   context.Context
@@ -69,8 +70,8 @@ func (ih *IPFSHandler) RetrieveBlock(hash string) (*Block, error) {
 }
 
 // StoreBlockchain stores the entire blockchain in IPFS
-func (ih *IPFSHandler) StoreBlockchain(blockchain *Blockchain) (string, error) {
-	blockchainData, err := json.Marshal(blockchain.Blocks)
+func (ih *IPFSHandler) StoreBlockchain(blocks []*Block) (string, error) {
+	blockchainData, err := json.Marshal(blocks)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal blockchain: %v", err)
 	}
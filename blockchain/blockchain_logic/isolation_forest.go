@@ -0,0 +1,412 @@
+// isolation_forest.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+)
+
+const (
+	// DefaultForestSeed seeds tree construction
+	DefaultForestSeed = 1
+	// DefaultForestTrees is the number of isolation trees
+	DefaultForestTrees = 100
+	// DefaultForestSampleSize is the number of training rows each tree is
+	// built from
+	DefaultForestSampleSize = 256
+	// DefaultFlagThreshold and DefaultRejectThreshold are the anomaly scores
+	// above which a transaction is flagged for review or rejected. Scores
+	// near 1 are clear anomalies, scores below 0.5 look normal.
+	DefaultFlagThreshold   = 0.6
+	DefaultRejectThreshold = 0.7
+)
+
+// IsolationForestValidator scores transactions by how easily random splits
+// isolate them from the training data, without using labels: anomalies sit
+// in sparse regions and end up in short paths. Scores map to accept, flag
+// and reject bands. Trees are built with a seeded generator, so the same
+// data and seed always give the same model.
+type IsolationForestValidator struct {
+	featureStats
+	// Seed, Trees and SampleSize control training
+	Seed       int64
+	Trees      int
+	SampleSize int
+	// FlagThreshold and RejectThreshold are the anomaly score bands. They
+	// are part of the trained model.
+	FlagThreshold   float64
+	RejectThreshold float64
+	// ValidationSplit is the fraction of the training data held out to
+	// evaluate the model
+	ValidationSplit float64
+	forest          *forestParams
+	fixed           *fixedForest
+}
+
+// isolationNode is a node of an isolation tree. Inner nodes send features
+// below Split to Left; leaves hold the expected path length that the rows
+// they still contain would have added.
+type isolationNode struct {
+	Feature int            `json:"feature,omitempty"`
+	Split   float64        `json:"split,omitempty"`
+	Left    *isolationNode `json:"left,omitempty"`
+	Right   *isolationNode `json:"right,omitempty"`
+	Adjust  float64        `json:"adjust,omitempty"`
+}
+
+// forestParams holds everything the isolation forest learned during training
+type forestParams struct {
+	Seed            int64            `json:"seed"`
+	SampleSize      int              `json:"sample_size"`
+	PathLength      float64          `json:"path_length"`     // Average path length of an unsuccessful search among SampleSize rows
+	ExpectedSplits  []float64        `json:"expected_splits"` // Average splits per feature on the paths of training rows
+	FlagThreshold   float64          `json:"flag_threshold"`
+	RejectThreshold float64          `json:"reject_threshold"`
+	Trees           []*isolationNode `json:"trees"`
+	statsParams
+}
+
+// fixedNode is an isolation tree node quantized to Q16
+type fixedNode struct {
+	feature     int
+	split       int64
+	left, right *fixedNode
+	adjust      int64
+}
+
+// fixedForest is the trained forest quantized to Q16
+type fixedForest struct {
+	fixedStats
+	trees           []*fixedNode
+	averagePath     int64
+	expectedSplits  []int64
+	flagThreshold   int64
+	rejectThreshold int64
+}
+
+// NewIsolationForestValidator creates an untrained isolation forest with the
+// default settings
+func NewIsolationForestValidator() *IsolationForestValidator {
+	fv := &IsolationForestValidator{
+		featureStats:    newFeatureStats(),
+		Seed:            DefaultForestSeed,
+		Trees:           DefaultForestTrees,
+		SampleSize:      DefaultForestSampleSize,
+		FlagThreshold:   DefaultFlagThreshold,
+		RejectThreshold: DefaultRejectThreshold,
+		ValidationSplit: DefaultValidationSplit,
+	}
+	fv.forest = fv.params()
+	fv.fixed = fv.forest.quantize()
+	return fv
+}
+
+// averagePathLength is c(n) = 2H(n-1) - 2(n-1)/n, the average path length
+// of an unsuccessful search in a binary search tree of n rows. It is computed
+// in integers and rounded to Q16, so the path lengths hashed into the model
+// are the same on every platform.
+func averagePathLength(n int) float64 {
+	if n <= 1 {
+		return 0
+	}
+	// Sum the harmonic number H(n-1) with 32 fractional bits
+	const one = int64(1) << 32
+	var harmonic int64
+	for k := int64(1); k < int64(n); k++ {
+		harmonic += (one + k/2) / k
+	}
+	c := 2*harmonic - 2*int64(n-1)*one/int64(n)
+	return fromFixed((c + one>>(fixedShift+1)) >> (32 - fixedShift))
+}
+
+// Train builds the forest from the rows of a CSV, holding out
+// ValidationSplit of them. Labels are not used for training; when present,
+// or derived from the amount rule, they only evaluate the bands.
+func (fv *IsolationForestValidator) Train(filepath string) (*TrainingReport, error) {
+	samples, labelSource, err := readTrainingData(filepath)
+	if err != nil {
+		return nil, err
+	}
+	if fv.RejectThreshold < fv.FlagThreshold {
+		return nil, fmt.Errorf("reject threshold %.2f is below flag threshold %.2f", fv.RejectThreshold, fv.FlagThreshold)
+	}
+	training, validation := splitSamples(samples, fv.ValidationSplit)
+
+	fv.fit(training)
+
+	rows := make([][]float64, len(training))
+	for i, sample := range training {
+		rows[i] = fv.extractFeatures(sample.sender, sample.receiver, sample.amount)
+	}
+	sampleSize := fv.SampleSize
+	if sampleSize > len(rows) {
+		sampleSize = len(rows)
+	}
+	heightLimit := bits.Len(uint(sampleSize - 1)) // ceil(log2(sampleSize))
+
+	rng := rand.New(rand.NewSource(fv.Seed))
+	trees := make([]*isolationNode, fv.Trees)
+	for t := range trees {
+		subsample := make([][]float64, sampleSize)
+		for i, row := range rng.Perm(len(rows))[:sampleSize] {
+			subsample[i] = rows[row]
+		}
+		trees[t] = buildIsolationTree(rng, subsample, 0, heightLimit)
+	}
+
+	forest := fv.params()
+	forest.SampleSize = sampleSize
+	forest.PathLength = averagePathLength(sampleSize)
+	forest.Trees = trees
+	fv.forest = forest
+
+	// The expected splits are measured with the quantized trees that score
+	// transactions, so a typical row has contributions near zero
+	fixed := forest.quantize()
+	forest.ExpectedSplits = make([]float64, numFeatures)
+	for _, sample := range training {
+		_, splits := fixed.pathLength(fixed.features(sample.sender, sample.receiver, sample.amount))
+		for i, count := range splits {
+			forest.ExpectedSplits[i] += float64(count) / float64(len(fixed.trees)*len(training))
+		}
+	}
+	fv.fixed = forest.quantize()
+
+	fmt.Printf("Built %d isolation trees from %d rows each (seed %d)\n", len(trees), sampleSize, fv.Seed)
+	return newTrainingReport(fv, labelSource, training, validation), nil
+}
+
+// buildIsolationTree splits rows on random features at random points until
+// each row is isolated or the height limit is reached
+func buildIsolationTree(rng *rand.Rand, rows [][]float64, depth, heightLimit int) *isolationNode {
+	if depth >= heightLimit || len(rows) <= 1 {
+		return &isolationNode{Adjust: averagePathLength(len(rows))}
+	}
+
+	// Only features that still vary can split the rows
+	var candidates []int
+	for feature := 0; feature < numFeatures; feature++ {
+		if low, high := featureRange(rows, feature); high > low {
+			candidates = append(candidates, feature)
+		}
+	}
+	if len(candidates) == 0 {
+		return &isolationNode{Adjust: averagePathLength(len(rows))}
+	}
+
+	feature := candidates[rng.Intn(len(candidates))]
+	low, high := featureRange(rows, feature)
+	// Round the product so the split is the same on FMA platforms
+	split := low + float64(rng.Float64()*(high-low))
+
+	var left, right [][]float64
+	for _, row := range rows {
+		if row[feature] < split {
+			left = append(left, row)
+		} else {
+			right = append(right, row)
+		}
+	}
+	return &isolationNode{
+		Feature: feature,
+		Split:   split,
+		Left:    buildIsolationTree(rng, left, depth+1, heightLimit),
+		Right:   buildIsolationTree(rng, right, depth+1, heightLimit),
+	}
+}
+
+// featureRange returns the smallest and largest value of a feature
+func featureRange(rows [][]float64, feature int) (float64, float64) {
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, row := range rows {
+		low = math.Min(low, row[feature])
+		high = math.Max(high, row[feature])
+	}
+	return low, high
+}
+
+// Validate scores a transaction with the fixed-point forest, so the decision
+// is the same on every node. The probability of being valid is one minus the
+// anomaly score. Each feature's contribution compares the splits it took to
+// isolate the transaction with the training average, scaled like the path
+// length; negative contributions are features on which it stands out.
+func (fv *IsolationForestValidator) Validate(tx Transaction) *ValidationResult {
+	fm := fv.fixed
+	features := fm.features(tx.Sender, tx.Receiver, tx.Amount)
+	pathLength, splits := fm.pathLength(features)
+	anomaly := fm.anomalyScore(pathLength)
+
+	trees := int64(len(fm.trees))
+	if trees == 0 {
+		trees = 1
+	}
+	contributions := make([]FeatureContribution, len(features))
+	bias := mulDiv(pathLength, fixedOne, fm.averagePath)
+	for i, feature := range features {
+		averageSplits := splits[i] * fixedOne / trees
+		contribution := mulDiv(averageSplits-fm.expectedSplits[i], fixedOne, fm.averagePath)
+		bias -= contribution
+		contributions[i] = FeatureContribution{
+			Feature:      featureNames[i],
+			Value:        fromFixed(feature),
+			Weight:       fromFixed(averageSplits),
+			Contribution: fromFixed(contribution),
+		}
+	}
+
+	band := BandAccept
+	switch {
+	case anomaly > fm.rejectThreshold:
+		band = BandReject
+	case anomaly > fm.flagThreshold:
+		band = BandFlag
+	}
+	return explainDecision(fixedOne-anomaly, fixedOne-fm.rejectThreshold, band, contributions, bias)
+}
+
+// Explain lists the transaction's features and how each one moves the score
+func (fv *IsolationForestValidator) Explain(tx Transaction) string {
+	return fv.Validate(tx).String()
+}
+
+// params returns the trained parameters of the forest
+func (fv *IsolationForestValidator) params() *forestParams {
+	params := &forestParams{
+		Seed:            fv.Seed,
+		FlagThreshold:   fv.FlagThreshold,
+		RejectThreshold: fv.RejectThreshold,
+		statsParams:     fv.featureStats.params(),
+	}
+	if fv.forest != nil {
+		params.SampleSize = fv.forest.SampleSize
+		params.PathLength = fv.forest.PathLength
+		params.ExpectedSplits = fv.forest.ExpectedSplits
+		params.Trees = fv.forest.Trees
+	}
+	return params
+}
+
+// ModelHash identifies the trained forest
+func (fv *IsolationForestValidator) ModelHash() string {
+	return hashParams(fv.params())
+}
+
+// Save writes the trained forest to w
+func (fv *IsolationForestValidator) Save(w io.Writer) error {
+	return writeModel(w, ValidatorIsolationForest, fv.params(), fv.reference)
+}
+
+// Load replaces the forest with one written by Save, after checking its
+// format version and model hash
+func (fv *IsolationForestValidator) Load(r io.Reader) error {
+	var params forestParams
+	reference, err := readModel(r, ValidatorIsolationForest, &params)
+	if err != nil {
+		return err
+	}
+	if len(params.ExpectedSplits) != numFeatures {
+		return fmt.Errorf("model has %d features, expected %d", len(params.ExpectedSplits), numFeatures)
+	}
+	for _, tree := range params.Trees {
+		if err := tree.check(); err != nil {
+			return err
+		}
+	}
+
+	fv.Seed = params.Seed
+	fv.Trees = len(params.Trees)
+	fv.SampleSize = params.SampleSize
+	fv.FlagThreshold = params.FlagThreshold
+	fv.RejectThreshold = params.RejectThreshold
+	fv.featureStats.load(params.statsParams)
+	fv.reference = reference
+	fv.forest = &params
+	fv.fixed = params.quantize()
+	return nil
+}
+
+// check verifies that every inner node has two children and splits on a
+// known feature
+func (n *isolationNode) check() error {
+	if n == nil {
+		return fmt.Errorf("model has a missing tree node")
+	}
+	if n.Left == nil && n.Right == nil {
+		return nil
+	}
+	if n.Feature < 0 || n.Feature >= numFeatures {
+		return fmt.Errorf("model splits on unknown feature %d", n.Feature)
+	}
+	if err := n.Left.check(); err != nil {
+		return err
+	}
+	return n.Right.check()
+}
+
+// quantize converts the trained forest to Q16
+func (p *forestParams) quantize() *fixedForest {
+	fm := &fixedForest{
+		fixedStats:      p.statsParams.quantize(),
+		trees:           make([]*fixedNode, len(p.Trees)),
+		averagePath:     toFixed(p.PathLength),
+		expectedSplits:  toFixedSlice(p.ExpectedSplits),
+		flagThreshold:   toFixed(p.FlagThreshold),
+		rejectThreshold: toFixed(p.RejectThreshold),
+	}
+	if len(fm.expectedSplits) != numFeatures {
+		fm.expectedSplits = make([]int64, numFeatures)
+	}
+	for t, tree := range p.Trees {
+		fm.trees[t] = tree.quantize()
+	}
+	return fm
+}
+
+// quantize converts a tree to Q16
+func (n *isolationNode) quantize() *fixedNode {
+	if n.Left == nil {
+		return &fixedNode{adjust: toFixed(n.Adjust)}
+	}
+	return &fixedNode{
+		feature: n.Feature,
+		split:   toFixed(n.Split),
+		left:    n.Left.quantize(),
+		right:   n.Right.quantize(),
+	}
+}
+
+// pathLength returns the average path length of the features over all trees
+// in Q16, and the number of splits taken on each feature
+func (fm *fixedForest) pathLength(features []int64) (int64, []int64) {
+	splits := make([]int64, numFeatures)
+	if len(fm.trees) == 0 {
+		return 0, splits
+	}
+	var total int64
+	for _, node := range fm.trees {
+		for node.left != nil {
+			splits[node.feature]++
+			total += fixedOne
+			if features[node.feature] < node.split {
+				node = node.left
+			} else {
+				node = node.right
+			}
+		}
+		total += node.adjust
+	}
+	return total / int64(len(fm.trees)), splits
+}
+
+// anomalyScore is 2^(-pathLength / c(SampleSize)) in Q16: close to 1 for
+// rows isolated quickly, below 0.5 for rows that take long to isolate
+func (fm *fixedForest) anomalyScore(pathLength int64) int64 {
+	if fm.averagePath <= 0 {
+		return fixedHalf
+	}
+	exponent := mulDiv(pathLength, fixedOne, fm.averagePath)
+	return fixedExpNeg(-mulDiv(exponent, fixedLn2, fixedOne))
+}
@@ -0,0 +1,164 @@
+// mempool.go
+package blockchain_logic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultMempoolSize is the default maximum number of pending transactions
+const DefaultMempoolSize = 5000
+
+// ErrDuplicateTransaction is returned when a transaction is already pooled
+var ErrDuplicateTransaction = errors.New("transaction already in pool")
+
+// poolEntry is a pending transaction with its arrival order
+type poolEntry struct {
+	tx  Transaction
+	seq uint64
+}
+
+// TransactionPool is the thread-safe mempool of signed transactions waiting
+// to be included in a block. When full, the oldest entries are evicted.
+type TransactionPool struct {
+	entries map[string]*poolEntry // Keyed by transaction hash
+	maxSize int
+	nextSeq uint64
+	mutex   sync.RWMutex
+}
+
+// NewTransactionPool creates a new transaction pool holding at most maxSize
+// transactions
+func NewTransactionPool(maxSize int) *TransactionPool {
+	if maxSize <= 0 {
+		maxSize = DefaultMempoolSize
+	}
+	return &TransactionPool{
+		entries: make(map[string]*poolEntry),
+		maxSize: maxSize,
+	}
+}
+
+// Add verifies and adds a transaction, evicting the oldest entry if the pool
+// is full
+func (tp *TransactionPool) Add(tx Transaction) error {
+	if err := tx.VerifySignature(); err != nil {
+		return fmt.Errorf("rejected transaction: %v", err)
+	}
+
+	hash := tx.Hash()
+
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+
+	if _, exists := tp.entries[hash]; exists {
+		return ErrDuplicateTransaction
+	}
+
+	if len(tp.entries) >= tp.maxSize {
+		tp.evictOldest()
+	}
+
+	tp.entries[hash] = &poolEntry{
+		tx:  tx,
+		seq: tp.nextSeq,
+	}
+	tp.nextSeq++
+	return nil
+}
+
+// evictOldest removes the entry that arrived first
+func (tp *TransactionPool) evictOldest() {
+	var oldestHash string
+	var oldest *poolEntry
+	for hash, entry := range tp.entries {
+		if oldest == nil || entry.seq < oldest.seq {
+			oldestHash, oldest = hash, entry
+		}
+	}
+	if oldest != nil {
+		delete(tp.entries, oldestHash)
+	}
+}
+
+// Contains reports whether a transaction hash is pooled
+func (tp *TransactionPool) Contains(hash string) bool {
+	tp.mutex.RLock()
+	defer tp.mutex.RUnlock()
+
+	_, exists := tp.entries[hash]
+	return exists
+}
+
+// Remove removes transactions by hash
+func (tp *TransactionPool) Remove(hashes ...string) {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+
+	for _, hash := range hashes {
+		delete(tp.entries, hash)
+	}
+}
+
+// RemoveIncluded evicts the transactions of a block and every pooled
+// transaction whose nonce has been used up according to the state
+func (tp *TransactionPool) RemoveIncluded(block *Block, state *WorldState) {
+	tp.mutex.Lock()
+	defer tp.mutex.Unlock()
+
+	for _, tx := range block.Transactions {
+		delete(tp.entries, tx.Hash())
+	}
+	for hash, entry := range tp.entries {
+		if entry.tx.Nonce < state.Nonce(entry.tx.Sender) {
+			delete(tp.entries, hash)
+		}
+	}
+}
+
+// Pending returns up to max transactions for a block template. Transactions
+// are taken in arrival order, except that each sender's transactions are
+// returned in nonce order.
+func (tp *TransactionPool) Pending(max int) []Transaction {
+	tp.mutex.RLock()
+	entries := make([]*poolEntry, 0, len(tp.entries))
+	for _, entry := range tp.entries {
+		entries = append(entries, entry)
+	}
+	tp.mutex.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+
+	// Queue each sender's transactions by nonce
+	bySender := make(map[string][]Transaction)
+	for _, entry := range entries {
+		bySender[entry.tx.Sender] = append(bySender[entry.tx.Sender], entry.tx)
+	}
+	for _, txs := range bySender {
+		sort.SliceStable(txs, func(i, j int) bool {
+			return txs[i].Nonce < txs[j].Nonce
+		})
+	}
+
+	pending := make([]Transaction, 0, len(entries))
+	for _, entry := range entries {
+		if max > 0 && len(pending) >= max {
+			break
+		}
+		queue := bySender[entry.tx.Sender]
+		pending = append(pending, queue[0])
+		bySender[entry.tx.Sender] = queue[1:]
+	}
+	return pending
+}
+
+// Size returns the number of pooled transactions
+func (tp *TransactionPool) Size() int {
+	tp.mutex.RLock()
+	defer tp.mutex.RUnlock()
+	return len(tp.entries)
+}
@@ -0,0 +1,128 @@
+// merkle.go
+package blockchain_logic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Leaf and interior nodes are hashed with different prefixes so a leaf can
+// never be passed off as an interior node
+const (
+	merkleLeafPrefix     byte = 0x00
+	merkleInteriorPrefix byte = 0x01
+)
+
+// emptyMerkleRoot is the root of a block without transactions
+var emptyMerkleRoot = hex.EncodeToString(make([]byte, sha256.Size))
+
+// MerkleProofStep is one sibling hash on the path from a leaf to the root
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // True if the sibling is the left operand
+}
+
+// MerkleProof proves that a transaction is included under a Merkle root
+type MerkleProof struct {
+	TxHash    string            `json:"tx_hash"`
+	BlockHash string            `json:"block_hash"`
+	Root      string            `json:"root"`
+	Steps     []MerkleProofStep `json:"steps"`
+}
+
+func merkleLeaf(txHash string) []byte {
+	data, _ := hex.DecodeString(txHash)
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, data...))
+	return hash[:]
+}
+
+func merkleInterior(left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, merkleInteriorPrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// merkleLevels builds the tree bottom-up. A node without a sibling is
+// promoted to the next level unchanged.
+func merkleLevels(txHashes []string) [][][]byte {
+	level := make([][]byte, len(txHashes))
+	for i, txHash := range txHashes {
+		level[i] = merkleLeaf(txHash)
+	}
+
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleInterior(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// ComputeMerkleRoot computes the Merkle root of a list of transaction hashes
+func ComputeMerkleRoot(txHashes []string) string {
+	if len(txHashes) == 0 {
+		return emptyMerkleRoot
+	}
+	levels := merkleLevels(txHashes)
+	return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+// BuildMerkleProof builds the inclusion proof for the transaction at index
+func BuildMerkleProof(txHashes []string, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(txHashes) {
+		return nil, fmt.Errorf("transaction index %d out of range", index)
+	}
+
+	levels := merkleLevels(txHashes)
+	proof := &MerkleProof{
+		TxHash: txHashes[index],
+		Root:   hex.EncodeToString(levels[len(levels)-1][0]),
+	}
+
+	position := index
+	for _, level := range levels[:len(levels)-1] {
+		sibling := position ^ 1
+		if sibling < len(level) {
+			proof.Steps = append(proof.Steps, MerkleProofStep{
+				Hash: hex.EncodeToString(level[sibling]),
+				Left: sibling < position,
+			})
+		}
+		position /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that the proof links txHash to the given Merkle
+// root, such as the one in a block header. It needs nothing but the proof.
+func VerifyMerkleProof(txHash, root string, proof *MerkleProof) bool {
+	if proof == nil || proof.TxHash != txHash {
+		return false
+	}
+
+	current := merkleLeaf(txHash)
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil || len(sibling) != sha256.Size {
+			return false
+		}
+		if step.Left {
+			current = merkleInterior(sibling, current)
+		} else {
+			current = merkleInterior(current, sibling)
+		}
+	}
+	return hex.EncodeToString(current) == root
+}
@@ -0,0 +1,145 @@
+// metrics.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ConfusionMatrix counts predictions against labels. The positive class is
+// an invalid transaction, the one the validator exists to catch.
+type ConfusionMatrix struct {
+	TruePositives  int `json:"true_positives"`  // Invalid and rejected
+	FalsePositives int `json:"false_positives"` // Valid but rejected
+	TrueNegatives  int `json:"true_negatives"`  // Valid and accepted
+	FalseNegatives int `json:"false_negatives"` // Invalid but accepted
+}
+
+// ClassificationMetrics describes how well the validator separates valid
+// from invalid transactions on a labeled set. Metrics that are undefined for
+// the set, such as ROC-AUC without invalid examples, are NaN.
+type ClassificationMetrics struct {
+	Samples   int             `json:"samples"`
+	Accuracy  float64         `json:"accuracy"`
+	Precision float64         `json:"precision"`
+	Recall    float64         `json:"recall"`
+	F1        float64         `json:"f1"`
+	ROCAUC    float64         `json:"roc_auc"`
+	Confusion ConfusionMatrix `json:"confusion"`
+}
+
+// TrainingReport is returned by Train
+type TrainingReport struct {
+	LabelSource       string                // "label column" or "amount rule"
+	TrainingSamples   int                   // Samples the model was fitted on
+	ValidationSamples int                   // Held-out samples, zero if there was no split
+	Metrics           ClassificationMetrics // On the validation set, or the training set without a split
+}
+
+// ratio returns a / b, or NaN if b is zero
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return math.NaN()
+	}
+	return a / b
+}
+
+// computeMetrics compares predictions with labels. invalid holds the label
+// of each sample, rejected the prediction and scores the predicted
+// probability that the sample is invalid.
+func computeMetrics(invalid, rejected []bool, scores []float64) ClassificationMetrics {
+	var cm ConfusionMatrix
+	for i := range invalid {
+		switch {
+		case invalid[i] && rejected[i]:
+			cm.TruePositives++
+		case !invalid[i] && rejected[i]:
+			cm.FalsePositives++
+		case !invalid[i] && !rejected[i]:
+			cm.TrueNegatives++
+		default:
+			cm.FalseNegatives++
+		}
+	}
+
+	tp, fp, tn, fn := float64(cm.TruePositives), float64(cm.FalsePositives), float64(cm.TrueNegatives), float64(cm.FalseNegatives)
+	metrics := ClassificationMetrics{
+		Samples:   len(invalid),
+		Accuracy:  ratio(tp+tn, tp+fp+tn+fn),
+		Precision: ratio(tp, tp+fp),
+		Recall:    ratio(tp, tp+fn),
+		ROCAUC:    rocAUC(invalid, scores),
+		Confusion: cm,
+	}
+	metrics.F1 = ratio(2*metrics.Precision*metrics.Recall, metrics.Precision+metrics.Recall)
+	return metrics
+}
+
+// rocAUC computes the area under the ROC curve as the probability that a
+// random invalid sample scores higher than a random valid one, counting ties
+// as half (the Mann-Whitney U statistic)
+func rocAUC(invalid []bool, scores []float64) float64 {
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return scores[order[a]] < scores[order[b]]
+	})
+
+	// Sum the ranks of the invalid samples, giving tied scores their
+	// average rank
+	var positives, negatives, rankSum float64
+	for start := 0; start < len(order); {
+		end := start
+		for end < len(order) && scores[order[end]] == scores[order[start]] {
+			end++
+		}
+		averageRank := float64(start+end+1) / 2
+		for _, i := range order[start:end] {
+			if invalid[i] {
+				positives++
+				rankSum += averageRank
+			} else {
+				negatives++
+			}
+		}
+		start = end
+	}
+
+	return ratio(rankSum-positives*(positives+1)/2, positives*negatives)
+}
+
+// formatMetric prints a metric, or n/a if it is undefined
+func formatMetric(value float64) string {
+	if math.IsNaN(value) {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.4f", value)
+}
+
+// String formats the report for the training log
+func (r *TrainingReport) String() string {
+	var b strings.Builder
+	evaluatedOn := "validation set"
+	if r.ValidationSamples == 0 {
+		evaluatedOn = "training set, no validation split"
+	}
+	cm := r.Metrics.Confusion
+
+	fmt.Fprintf(&b, "Labels: %s\n", r.LabelSource)
+	fmt.Fprintf(&b, "Training samples: %d, validation samples: %d\n", r.TrainingSamples, r.ValidationSamples)
+	fmt.Fprintf(&b, "Evaluated on the %s (positive class: invalid)\n", evaluatedOn)
+	fmt.Fprintf(&b, "Accuracy:  %s\n", formatMetric(r.Metrics.Accuracy))
+	fmt.Fprintf(&b, "Precision: %s\n", formatMetric(r.Metrics.Precision))
+	fmt.Fprintf(&b, "Recall:    %s\n", formatMetric(r.Metrics.Recall))
+	fmt.Fprintf(&b, "F1:        %s\n", formatMetric(r.Metrics.F1))
+	fmt.Fprintf(&b, "ROC-AUC:   %s\n", formatMetric(r.Metrics.ROCAUC))
+	fmt.Fprintf(&b, "Confusion matrix:\n")
+	fmt.Fprintf(&b, "                  rejected  accepted\n")
+	fmt.Fprintf(&b, "  label invalid   %8d  %8d\n", cm.TruePositives, cm.FalseNegatives)
+	fmt.Fprintf(&b, "  label valid     %8d  %8d\n", cm.FalsePositives, cm.TrueNegatives)
+	return b.String()
+}
@@ -0,0 +1,141 @@
+// miner.go
+package blockchain_logic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStaleTip is returned when mining is aborted because the chain tip moved
+var ErrStaleTip = errors.New("chain tip changed while mining")
+
+// minerCheckInterval is the number of hashes between cancellation checks
+const minerCheckInterval = 1024
+
+// Miner solves proof of work using several goroutines, each searching an
+// interleaved slice of the nonce space
+type Miner struct {
+	Workers int
+
+	hashes       atomic.Uint64 // Hashes computed by the current run
+	hashrateBits atomic.Uint64 // float64 bits of the last run's hashrate
+}
+
+// NewMiner creates a miner. A workers value of zero or less uses one worker
+// per CPU.
+func NewMiner(workers int) *Miner {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Miner{Workers: workers}
+}
+
+// Hashrate returns the hashes per second of the last mining run
+func (m *Miner) Hashrate() float64 {
+	return math.Float64frombits(m.hashrateBits.Load())
+}
+
+// Mine searches for a nonce that satisfies the block's target. It returns
+// the context error if the context is cancelled first. With a single worker
+// the lowest valid nonce is always found, which keeps mining deterministic.
+func (m *Miner) Mine(ctx context.Context, block *Block) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	target := block.Target()
+	start := time.Now()
+	m.hashes.Store(0)
+
+	found := make(chan BlockHeader, m.Workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < m.Workers; worker++ {
+		wg.Add(1)
+		go func(header BlockHeader, first int64) {
+			defer wg.Done()
+			m.search(ctx, header, first, int64(m.Workers), target, found)
+		}(block.BlockHeader, block.Nonce+int64(worker))
+	}
+
+	var result error
+	select {
+	case header := <-found:
+		block.Nonce = header.Nonce
+		block.Hash = header.Hash
+	case <-ctx.Done():
+		result = ctx.Err()
+	}
+	cancel()
+	wg.Wait()
+
+	elapsed := time.Since(start).Seconds()
+	if elapsed > 0 {
+		m.hashrateBits.Store(math.Float64bits(float64(m.hashes.Load()) / elapsed))
+	}
+
+	if result == nil {
+		fmt.Printf("Block mined! Hash: %s (%.0f H/s)\n", block.Hash, m.Hashrate())
+	}
+	return result
+}
+
+// search tries nonces first, first+step, first+2*step, ... on its own copy
+// of the header until it finds a solution or the context is cancelled
+func (m *Miner) search(ctx context.Context, header BlockHeader, first, step int64, target *big.Int, found chan<- BlockHeader) {
+	header.Nonce = first
+	for pending := uint64(1); ; pending++ {
+		header.Hash = header.CalculateHash()
+		if meetsTarget(header.Hash, target) {
+			m.hashes.Add(pending)
+			found <- header
+			return
+		}
+
+		if pending == minerCheckInterval {
+			m.hashes.Add(pending)
+			pending = 0
+			select {
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+		header.Nonce += step
+	}
+}
+
+// MineNext builds a block with the given transactions on top of the current
+// tip and mines it. Mining is aborted with ErrStaleTip as soon as another
+// block becomes the tip, or with the context error if ctx is cancelled.
+func (m *Miner) MineNext(ctx context.Context, bc *Blockchain, transactions []Transaction) (*Block, error) {
+	block, tipChanged := bc.NewBlockTemplate(transactions)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stale := make(chan struct{})
+	go func() {
+		select {
+		case <-tipChanged:
+			close(stale)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := m.Mine(ctx, block); err != nil {
+		select {
+		case <-stale:
+			return nil, ErrStaleTip
+		default:
+			return nil, err
+		}
+	}
+	return block, nil
+}
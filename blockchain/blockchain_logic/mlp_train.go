@@ -0,0 +1,98 @@
+// mlp_train.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	G "gorgonia.org/gorgonia"
+	"gorgonia.org/tensor"
+)
+
+// glorotUniform returns seeded weights for a fanIn x fanOut layer
+func glorotUniform(rng *rand.Rand, fanIn, fanOut int) []float64 {
+	limit := math.Sqrt(6 / float64(fanIn+fanOut))
+	weights := make([]float64, fanIn*fanOut)
+	for i := range weights {
+		weights[i] = float64(float64(rng.Float64()*2-1) * limit)
+	}
+	return weights
+}
+
+// trainNetwork fits the network to the labels with gorgonia, by full-batch
+// gradient descent on the binary cross-entropy. The learned float weights
+// are exported into the Q16 network used for scoring. Gorgonia's float
+// kernels do not give bit-identical weights on every platform, so the model
+// hash that the genesis block commits to can differ between nodes: a network
+// is trained once, saved with -save-model and shared with -model.
+func (mv *MLPValidator) trainNetwork(inputs [][]float64, labels []float64) error {
+	n := len(inputs)
+	if n == 0 {
+		return fmt.Errorf("no training samples")
+	}
+	fmt.Println("Note: the MLP model hash depends on the platform it was trained on; share the model with -save-model and -model")
+	backing := make([]float64, 0, n*numFeatures)
+	for _, features := range inputs {
+		backing = append(backing, features...)
+	}
+
+	rng := rand.New(rand.NewSource(mlpSeed))
+	g := G.NewGraph()
+	x := G.NewMatrix(g, tensor.Float64, G.WithShape(n, numFeatures), G.WithName("x"),
+		G.WithValue(tensor.New(tensor.WithShape(n, numFeatures), tensor.WithBacking(backing))))
+	y := G.NewMatrix(g, tensor.Float64, G.WithShape(n, 1), G.WithName("y"),
+		G.WithValue(tensor.New(tensor.WithShape(n, 1), tensor.WithBacking(labels))))
+	w1 := G.NewMatrix(g, tensor.Float64, G.WithShape(numFeatures, mlpHiddenUnits), G.WithName("w1"),
+		G.WithValue(tensor.New(tensor.WithShape(numFeatures, mlpHiddenUnits), tensor.WithBacking(glorotUniform(rng, numFeatures, mlpHiddenUnits)))))
+	b1 := G.NewMatrix(g, tensor.Float64, G.WithShape(1, mlpHiddenUnits), G.WithName("b1"), G.WithInit(G.Zeroes()))
+	w2 := G.NewMatrix(g, tensor.Float64, G.WithShape(mlpHiddenUnits, 1), G.WithName("w2"),
+		G.WithValue(tensor.New(tensor.WithShape(mlpHiddenUnits, 1), tensor.WithBacking(glorotUniform(rng, mlpHiddenUnits, 1)))))
+	b2 := G.NewMatrix(g, tensor.Float64, G.WithShape(1, 1), G.WithName("b2"), G.WithInit(G.Zeroes()))
+
+	// Forward pass: sigmoid(relu(x*w1 + b1)*w2 + b2)
+	hidden := G.Must(G.Rectify(G.Must(G.BroadcastAdd(G.Must(G.Mul(x, w1)), b1, nil, []byte{0}))))
+	output := G.Must(G.Sigmoid(G.Must(G.BroadcastAdd(G.Must(G.Mul(hidden, w2)), b2, nil, []byte{0}))))
+	one := G.NewConstant(1.0)
+	likelihood := G.Must(G.Add(
+		G.Must(G.HadamardProd(y, G.Must(G.Log(output)))),
+		G.Must(G.HadamardProd(G.Must(G.Sub(one, y)), G.Must(G.Log(G.Must(G.Sub(one, output))))))))
+	loss := G.Must(G.Neg(G.Must(G.Mean(likelihood))))
+
+	var lossValue G.Value
+	G.Read(loss, &lossValue)
+
+	learnables := G.Nodes{w1, b1, w2, b2}
+	if _, err := G.Grad(loss, learnables...); err != nil {
+		return fmt.Errorf("failed to build gradients: %v", err)
+	}
+
+	vm := G.NewTapeMachine(g, G.BindDualValues(learnables...))
+	defer vm.Close()
+	solver := G.NewVanillaSolver(G.WithLearnRate(mlpLearningRate))
+
+	for epoch := 0; epoch < mlpEpochs; epoch++ {
+		if err := vm.RunAll(); err != nil {
+			return fmt.Errorf("training failed at epoch %d: %v", epoch, err)
+		}
+		if err := solver.Step(G.NodesToValueGrads(learnables)); err != nil {
+			return fmt.Errorf("training failed at epoch %d: %v", epoch, err)
+		}
+		if epoch%400 == 0 {
+			fmt.Printf("Epoch %d, Loss: %v\n", epoch, lossValue)
+		}
+		vm.Reset()
+	}
+
+	// Copy the learned weights out of the graph, one row per hidden unit
+	w1Values := w1.Value().Data().([]float64)
+	for j := 0; j < mlpHiddenUnits; j++ {
+		for i := 0; i < numFeatures; i++ {
+			mv.hiddenWeights[j][i] = w1Values[i*mlpHiddenUnits+j]
+		}
+	}
+	copy(mv.hiddenBias, b1.Value().Data().([]float64))
+	copy(mv.outputWeights, w2.Value().Data().([]float64))
+	mv.outputBias = b2.Value().Data().([]float64)[0]
+	return nil
+}
@@ -0,0 +1,202 @@
+// mlp_validator.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// mlpHiddenUnits is the width of the hidden layer
+	mlpHiddenUnits = 8
+	// mlpEpochs and mlpLearningRate control full-batch gradient descent
+	mlpEpochs       = 2000
+	mlpLearningRate = 0.1
+	// mlpSeed seeds the weight initialization, so every node trains the
+	// same network from the same data
+	mlpSeed = 1
+)
+
+// MLPValidator classifies transactions with a small neural network: one
+// hidden layer of ReLU units and a sigmoid output giving the probability
+// that the transaction is valid. The network is trained with gorgonia and
+// its weights are exported to the same Q16 fixed point as the logistic model
+// for scoring.
+type MLPValidator struct {
+	featureStats
+	hiddenWeights [][]float64 // One row of feature weights per hidden unit
+	hiddenBias    []float64
+	outputWeights []float64
+	outputBias    float64
+	// ValidationSplit is the fraction of the training data held out to
+	// evaluate the model
+	ValidationSplit float64
+	// Fixed-point copy of the network used for validation decisions
+	fixed *fixedMLP
+}
+
+// mlpParams holds everything the MLP validator learned during training
+type mlpParams struct {
+	HiddenWeights [][]float64 `json:"hidden_weights"`
+	HiddenBias    []float64   `json:"hidden_bias"`
+	OutputWeights []float64   `json:"output_weights"`
+	OutputBias    float64     `json:"output_bias"`
+	statsParams
+}
+
+// fixedMLP is the trained network quantized to Q16
+type fixedMLP struct {
+	fixedStats
+	hiddenWeights [][]int64
+	hiddenBias    []int64
+	outputWeights []int64
+	outputBias    int64
+}
+
+// NewMLPValidator creates an untrained MLP validator
+func NewMLPValidator() *MLPValidator {
+	mv := &MLPValidator{
+		featureStats:    newFeatureStats(),
+		hiddenWeights:   make([][]float64, mlpHiddenUnits),
+		hiddenBias:      make([]float64, mlpHiddenUnits),
+		outputWeights:   make([]float64, mlpHiddenUnits),
+		ValidationSplit: DefaultValidationSplit,
+	}
+	for i := range mv.hiddenWeights {
+		mv.hiddenWeights[i] = make([]float64, numFeatures)
+	}
+	mv.fixed = mv.params().quantize()
+	return mv
+}
+
+// Train fits the network on a labeled CSV, holding out ValidationSplit of
+// the rows, and reports how the network performs on them
+func (mv *MLPValidator) Train(filepath string) (*TrainingReport, error) {
+	samples, labelSource, err := readTrainingData(filepath)
+	if err != nil {
+		return nil, err
+	}
+	training, validation := splitSamples(samples, mv.ValidationSplit)
+
+	mv.fit(training)
+
+	inputs := make([][]float64, len(training))
+	labels := make([]float64, len(training))
+	for i, sample := range training {
+		inputs[i] = mv.extractFeatures(sample.sender, sample.receiver, sample.amount)
+		if sample.valid {
+			labels[i] = 1
+		}
+	}
+	if err := mv.trainNetwork(inputs, labels); err != nil {
+		return nil, err
+	}
+	mv.fixed = mv.params().quantize()
+
+	return newTrainingReport(mv, labelSource, training, validation), nil
+}
+
+// Validate scores a transaction with the fixed-point network, so the
+// decision is the same on every node
+func (mv *MLPValidator) Validate(tx Transaction) *ValidationResult {
+	features := mv.fixed.features(tx.Sender, tx.Receiver, tx.Amount)
+	weights, bias := mv.fixed.linearize(features)
+	return newValidationResult(mv.fixed.predict(features), features, weights, bias)
+}
+
+// Explain lists the transaction's features and how each one moves the score
+func (mv *MLPValidator) Explain(tx Transaction) string {
+	return mv.Validate(tx).String()
+}
+
+// params returns the trained parameters of the network
+func (mv *MLPValidator) params() *mlpParams {
+	return &mlpParams{
+		HiddenWeights: mv.hiddenWeights,
+		HiddenBias:    mv.hiddenBias,
+		OutputWeights: mv.outputWeights,
+		OutputBias:    mv.outputBias,
+		statsParams:   mv.featureStats.params(),
+	}
+}
+
+// ModelHash identifies the trained network
+func (mv *MLPValidator) ModelHash() string {
+	return hashParams(mv.params())
+}
+
+// Save writes the trained network to w
+func (mv *MLPValidator) Save(w io.Writer) error {
+	return writeModel(w, ValidatorMLP, mv.params(), mv.reference)
+}
+
+// Load replaces the network with one written by Save, after checking its
+// format version, shape and model hash
+func (mv *MLPValidator) Load(r io.Reader) error {
+	var params mlpParams
+	reference, err := readModel(r, ValidatorMLP, &params)
+	if err != nil {
+		return err
+	}
+	if len(params.HiddenWeights) != mlpHiddenUnits || len(params.HiddenBias) != mlpHiddenUnits || len(params.OutputWeights) != mlpHiddenUnits {
+		return fmt.Errorf("model has %d hidden units, expected %d", len(params.HiddenWeights), mlpHiddenUnits)
+	}
+	for _, weights := range params.HiddenWeights {
+		if len(weights) != numFeatures {
+			return fmt.Errorf("model has %d inputs, expected %d", len(weights), numFeatures)
+		}
+	}
+
+	mv.hiddenWeights = params.HiddenWeights
+	mv.hiddenBias = params.HiddenBias
+	mv.outputWeights = params.OutputWeights
+	mv.outputBias = params.OutputBias
+	mv.featureStats.load(params.statsParams)
+	mv.reference = reference
+	mv.fixed = mv.params().quantize()
+	return nil
+}
+
+// quantize converts the trained float network to Q16
+func (p *mlpParams) quantize() *fixedMLP {
+	fm := &fixedMLP{
+		fixedStats:    p.statsParams.quantize(),
+		hiddenWeights: make([][]int64, len(p.HiddenWeights)),
+		hiddenBias:    toFixedSlice(p.HiddenBias),
+		outputWeights: toFixedSlice(p.OutputWeights),
+		outputBias:    toFixed(p.OutputBias),
+	}
+	for j, weights := range p.HiddenWeights {
+		fm.hiddenWeights[j] = toFixedSlice(weights)
+	}
+	return fm
+}
+
+// predict returns the probability that a transaction is valid, in Q16
+func (fm *fixedMLP) predict(features []int64) int64 {
+	activations := make([]int64, len(fm.hiddenWeights))
+	for j, weights := range fm.hiddenWeights {
+		if sum := fixedDot(features, weights, fm.hiddenBias[j]); sum > 0 {
+			activations[j] = sum
+		}
+	}
+	return fixedSigmoid(fixedDot(activations, fm.outputWeights, fm.outputBias))
+}
+
+// linearize returns the weights and bias of the linear function the network
+// computes around the features. Each ReLU unit is either off or passes its
+// input through, so near a given input the logit is linear in the features.
+func (fm *fixedMLP) linearize(features []int64) ([]int64, int64) {
+	weights := make([]int64, len(features))
+	bias := fm.outputBias
+	for j, unitWeights := range fm.hiddenWeights {
+		if fixedDot(features, unitWeights, fm.hiddenBias[j]) <= 0 {
+			continue
+		}
+		for i, weight := range unitWeights {
+			weights[i] += mulDiv(weight, fm.outputWeights[j], fixedOne)
+		}
+		bias += mulDiv(fm.hiddenBias[j], fm.outputWeights[j], fixedOne)
+	}
+	return weights, bias
+}
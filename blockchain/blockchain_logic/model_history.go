@@ -0,0 +1,103 @@
+// model_history.go
+package blockchain_logic
+
+// modelHistory tracks the validator model committed by the blocks of a
+// chain. The model trained at startup is committed by the genesis block.
+// With a promotion interval, a working copy learns from every block and is
+// promoted to be the committed model every interval blocks.
+type modelHistory struct {
+	interval int64
+	learner  OnlineValidator        // Working copy, nil when learning is disabled
+	promoted []TransactionValidator // promoted[i] is committed by blocks i*interval+1 to (i+1)*interval
+	hashes   []string               // Commitment of each promoted model
+}
+
+// modelCommitment is the hash that blocks commit to for a model. With online
+// learning it also covers the promotion interval, so nodes promoting at
+// different intervals start from different genesis blocks and never connect.
+func modelCommitment(validator TransactionValidator, interval int64) string {
+	if interval == 0 {
+		return validator.ModelHash()
+	}
+	return hashParams(struct {
+		ModelHash         string `json:"model_hash"`
+		PromotionInterval int64  `json:"promotion_interval"`
+	}{validator.ModelHash(), interval})
+}
+
+// newModelHistory starts the history of a chain from its genesis model. A
+// zero interval disables learning.
+func newModelHistory(genesis TransactionValidator, interval int64) *modelHistory {
+	mh := &modelHistory{
+		interval: interval,
+		promoted: []TransactionValidator{genesis},
+		hashes:   []string{modelCommitment(genesis, interval)},
+	}
+	if interval > 0 {
+		mh.learner = genesis.(OnlineValidator).Clone()
+	}
+	return mh
+}
+
+// active returns the model that a block at the given height commits to, and
+// its hash. The history must cover the blocks before it.
+func (mh *modelHistory) active(height int64) (TransactionValidator, string) {
+	i := int64(0)
+	if mh.interval > 0 && height > 0 {
+		i = (height - 1) / mh.interval
+	}
+	return mh.promoted[i], mh.hashes[i]
+}
+
+// connect learns from the next block of the chain and reports whether it
+// completed an interval and promoted a new model
+func (mh *modelHistory) connect(block *Block) bool {
+	if mh.learner == nil {
+		return false
+	}
+	mh.learner.Learn(block.Transactions)
+	if block.Index%mh.interval != 0 {
+		return false
+	}
+	promoted := mh.learner.Clone()
+	mh.promoted = append(mh.promoted, promoted)
+	mh.hashes = append(mh.hashes, modelCommitment(promoted, mh.interval))
+	return true
+}
+
+// extend returns a copy of the history that has also learned from the next
+// block of the chain. The receiver is left unchanged.
+func (mh *modelHistory) extend(block *Block) *modelHistory {
+	if mh.learner == nil {
+		return mh
+	}
+	extended := &modelHistory{
+		interval: mh.interval,
+		learner:  mh.learner.Clone(),
+		promoted: append([]TransactionValidator(nil), mh.promoted...),
+		hashes:   append([]string(nil), mh.hashes...),
+	}
+	extended.connect(block)
+	return extended
+}
+
+// rollback returns a copy of the history as it was after the block at the
+// given height of chain, such as the fork point of a reorg. The working copy
+// restarts from the last model promoted at or before that height and
+// relearns the blocks since. The receiver is left unchanged.
+func (mh *modelHistory) rollback(chain []*Block, height int64) *modelHistory {
+	if mh.learner == nil {
+		return mh
+	}
+	last := height / mh.interval
+	rolledBack := &modelHistory{
+		interval: mh.interval,
+		learner:  mh.promoted[last].(OnlineValidator).Clone(),
+		promoted: append([]TransactionValidator(nil), mh.promoted[:last+1]...),
+		hashes:   append([]string(nil), mh.hashes[:last+1]...),
+	}
+	for _, block := range chain[last*mh.interval+1 : height+1] {
+		rolledBack.learner.Learn(block.Transactions)
+	}
+	return rolledBack
+}
@@ -2,6 +2,7 @@ package blockchain_logic
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
@@ -11,42 +12,110 @@ import (
 type MessageType string
 
 const (
-	MessageTypeNewBlock           MessageType = "NEW_BLOCK"
-	MessageTypeNewTx              MessageType = "NEW_TRANSACTION"
-	MessageTypeBlockchain         MessageType = "BLOCKCHAIN_REQUEST"
-	MessageTypeBlockchainResponse MessageType = "BLOCKCHAIN_RESPONSE"
-	MessageTypeIPFSBackup         MessageType = "IPFS_BACKUP" // New message type
+	MessageTypeNewBlock   MessageType = "NEW_BLOCK"
+	MessageTypeNewTx      MessageType = "NEW_TRANSACTION"
+	MessageTypeIPFSBackup MessageType = "IPFS_BACKUP" // New message type
+	MessageTypeHello      MessageType = "HELLO"
+	MessageTypeGetHeaders MessageType = "GET_HEADERS"
+	MessageTypeHeaders    MessageType = "HEADERS"
+	MessageTypeGetBlocks  MessageType = "GET_BLOCKS"
+	MessageTypeBlocks     MessageType = "BLOCKS"
 )
 
-// BlockchainMessage represents a network message with blockchain-specific content
+var (
+	// ErrUnknownMessageType is returned when a message type has no registered payload
+	ErrUnknownMessageType = errors.New("unknown message type")
+	// ErrInvalidPayload is returned when a message payload does not match its type
+	ErrInvalidPayload = errors.New("invalid message payload")
+)
+
+// payloadTypes maps each message type to a constructor for its decoded
+// content
+var payloadTypes = map[MessageType]func() interface{}{
+	MessageTypeNewBlock:   func() interface{} { return &Block{} },
+	MessageTypeNewTx:      func() interface{} { return &Transaction{} },
+	MessageTypeIPFSBackup: func() interface{} { return new(string) },
+	MessageTypeHello:      func() interface{} { return &HelloPayload{} },
+	MessageTypeGetHeaders: func() interface{} { return &GetHeadersPayload{} },
+	MessageTypeHeaders:    func() interface{} { return &[]BlockHeader{} },
+	MessageTypeGetBlocks:  func() interface{} { return &GetBlocksPayload{} },
+	MessageTypeBlocks:     func() interface{} { return &[]*Block{} },
+}
+
+// BlockchainMessage represents a network message with blockchain-specific content.
+// After decoding, Content holds a pointer to the concrete type registered for
+// the message type in payloadTypes.
+//
+// Gossiped messages carry an ID derived from their content and Origin, the
+// node that created them, and a TTL that is decremented on every hop. From is
+// the peer that sent this copy of the message.
 type BlockchainMessage struct {
 	Type    MessageType `json:"type"`
 	Content interface{} `json:"content"`
 	From    string      `json:"from"`
 	To      string      `json:"to,omitempty"`
+	ID      string      `json:"id,omitempty"`
+	Origin  string      `json:"origin,omitempty"`
+	TTL     int         `json:"ttl,omitempty"`
 }
 
-// PeerConnection represents a connection to a peer
-type PeerConnection struct {
-	Address string
-	Conn    net.Conn
+// UnmarshalJSON decodes the message envelope and then its content into the
+// Go type registered for the message type
+func (m *BlockchainMessage) UnmarshalJSON(data []byte) error {
+	var envelope struct {
+		Type    MessageType     `json:"type"`
+		Content json.RawMessage `json:"content"`
+		From    string          `json:"from"`
+		To      string          `json:"to,omitempty"`
+		ID      string          `json:"id,omitempty"`
+		Origin  string          `json:"origin,omitempty"`
+		TTL     int             `json:"ttl,omitempty"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return err
+	}
+
+	m.Type = envelope.Type
+	m.From = envelope.From
+	m.To = envelope.To
+	m.ID = envelope.ID
+	m.Origin = envelope.Origin
+	m.TTL = envelope.TTL
+	m.Content = nil
+
+	newPayload, known := payloadTypes[envelope.Type]
+	if !known {
+		return fmt.Errorf("%w: %q", ErrUnknownMessageType, envelope.Type)
+	}
+
+	content := newPayload()
+	if len(envelope.Content) == 0 || string(envelope.Content) == "null" {
+		return fmt.Errorf("%w: %s message has no content", ErrInvalidPayload, envelope.Type)
+	}
+	if err := json.Unmarshal(envelope.Content, content); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidPayload, envelope.Type, err)
+	}
+	m.Content = content
+	return nil
 }
 
 // PeerNetwork manages peer connections and message broadcasting
 type PeerNetwork struct {
-	MyAddress   string
-	Peers       map[string]*PeerConnection
-	mutex       sync.RWMutex
-	isConnected map[string]bool
-	blockchain  *Blockchain // Reference to the blockchain
+	MyAddress  string
+	NodeID     string
+	Peers      map[string]*PeerConnection // Handshaken peers keyed by node ID
+	mutex      sync.RWMutex
+	blockchain *Blockchain // Reference to the blockchain
+	seen       *seenCache  // IDs of gossip messages already processed
 }
 
 // NewPeerNetwork creates a new peer network
 func NewPeerNetwork(myAddress string) *PeerNetwork {
 	return &PeerNetwork{
-		MyAddress:   myAddress,
-		Peers:       make(map[string]*PeerConnection),
-		isConnected: make(map[string]bool),
+		MyAddress: myAddress,
+		NodeID:    newNodeID(),
+		Peers:     make(map[string]*PeerConnection),
+		seen:      newSeenCache(seenCacheCapacity, seenCacheExpiry),
 	}
 }
 
@@ -60,11 +129,7 @@ func (pn *PeerNetwork) ConnectToPeersWithRetry(peerAddresses []string, maxRetrie
 		go func(address string) {
 			retryCount := 0
 			for {
-				pn.mutex.RLock()
-				isConnected := pn.isConnected[address]
-				pn.mutex.RUnlock()
-
-				if isConnected {
+				if pn.IsConnected(address) {
 					time.Sleep(5 * time.Second)
 					continue
 				}
@@ -83,18 +148,10 @@ func (pn *PeerNetwork) ConnectToPeersWithRetry(peerAddresses []string, maxRetrie
 					break
 				}
 
-				pn.mutex.Lock()
-				pn.Peers[address] = &PeerConnection{
-					Address: address,
-					Conn:    conn,
-				}
-				pn.isConnected[address] = true
-				pn.mutex.Unlock()
-
 				fmt.Printf("Successfully connected to peer: %s\n", address)
 
-				// Start handling messages from this peer
-				go pn.handleMessages(conn)
+				// Handshake and start handling messages from this peer
+				go pn.runPeer(conn, true)
 				break
 			}
 		}(addr)
@@ -125,176 +182,235 @@ func (pn *PeerNetwork) StartServer() {
 
 // handleConnection handles incoming peer connections
 func (pn *PeerNetwork) handleConnection(conn net.Conn) {
-	remoteAddr := conn.RemoteAddr().String()
-	fmt.Printf("New connection from: %s\n", remoteAddr)
-
-	pn.mutex.Lock()
-	if _, exists := pn.Peers[remoteAddr]; !exists {
-		pn.Peers[remoteAddr] = &PeerConnection{
-			Address: remoteAddr,
-			Conn:    conn,
-		}
-		pn.isConnected[remoteAddr] = true
+	fmt.Printf("New connection from: %s\n", conn.RemoteAddr())
+	pn.runPeer(conn, false)
+}
+
+// runPeer performs the handshake on a new connection, registers the peer and
+// handles its messages until the connection closes
+func (pn *PeerNetwork) runPeer(conn net.Conn, outbound bool) {
+	peer := newPeerConnection(conn, outbound)
+	decoder, err := pn.handshake(peer)
+	if err != nil {
+		fmt.Printf("Handshake with %s failed: %v\n", conn.RemoteAddr(), err)
+		peer.Close()
+		return
+	}
+
+	peer.start()
+
+	if !pn.registerPeer(peer) {
+		fmt.Printf("Closing duplicate connection to node %s (%s)\n", peer.NodeID, peer.Address)
+		peer.Close()
+		return
 	}
-	pn.mutex.Unlock()
 
-	go pn.handleMessages(conn)
+	fmt.Printf("Handshake complete with node %s at %s (version %d, height %d)\n",
+		peer.NodeID, peer.Address, peer.Version, peer.BestHeight)
+	pn.startSync(peer)
+	pn.handleMessages(peer, decoder)
 }
 
 // handleMessages handles incoming messages from a peer
-func (pn *PeerNetwork) handleMessages(conn net.Conn) {
+func (pn *PeerNetwork) handleMessages(peer *PeerConnection, decoder *json.Decoder) {
 	defer func() {
-		conn.Close()
-		addr := conn.RemoteAddr().String()
-		pn.mutex.Lock()
-		delete(pn.Peers, addr)
-		pn.isConnected[addr] = false
-		pn.mutex.Unlock()
-		fmt.Printf("Connection closed with peer: %s\n", addr)
+		peer.Close()
+		pn.unregisterPeer(peer)
+		fmt.Printf("Connection closed with peer: %s\n", peer.Address)
 	}()
 
-	decoder := json.NewDecoder(conn)
 	for {
 		var message BlockchainMessage
 		if err := decoder.Decode(&message); err != nil {
-			fmt.Printf("Error decoding message from %s: %v\n", conn.RemoteAddr(), err)
+			// The decoder has consumed the whole message, so a bad payload
+			// only drops that message and not the connection
+			if errors.Is(err, ErrUnknownMessageType) || errors.Is(err, ErrInvalidPayload) {
+				fmt.Printf("Dropping message from %s: %v\n", peer.Address, err)
+				continue
+			}
+			fmt.Printf("Error decoding message from %s: %v\n", peer.Address, err)
 			return
 		}
 
-		pn.handleMessage(message, conn)
+		pn.handleMessage(message, peer)
 	}
 }
 
 // handleMessage processes different types of blockchain messages
-func (pn *PeerNetwork) handleMessage(message BlockchainMessage, conn net.Conn) {
+func (pn *PeerNetwork) handleMessage(message BlockchainMessage, peer *PeerConnection) {
+	if message.ID != "" {
+		if message.ID != ComputeMessageID(message.Type, message.Origin, message.Content) {
+			fmt.Printf("Dropping %s message from %s: message ID does not match content\n", message.Type, message.From)
+			return
+		}
+		if !pn.seen.MarkSeen(message.ID) {
+			return // Already processed this gossip message
+		}
+	}
+
 	switch message.Type {
+	case MessageTypeHello:
+		// Handshake already completed; a repeated HELLO carries nothing new
+
 	case MessageTypeNewBlock:
 		if block, ok := message.Content.(*Block); ok {
 			fmt.Printf("Received new block from %s with hash %s\n", message.From, block.Hash)
 			// Validate and add block to blockchain
 			if pn.blockchain != nil {
 				if err := pn.blockchain.AddBlock(block); err != nil {
-					fmt.Printf("Error adding received block: %v\n", err)
+					if errors.Is(err, ErrOrphanBlock) {
+						fmt.Printf("Block %s is an orphan, requesting its ancestors from %s\n", block.Hash, peer.Address)
+						pn.requestMissingBlocks(peer, block)
+					} else if !errors.Is(err, ErrKnownBlock) {
+						fmt.Printf("Error adding received block: %v\n", err)
+					}
 				} else {
 					// Forward the block to other peers (flooding)
-					pn.BroadcastNewBlock(block)
+					pn.forwardMessage(message, peer)
 				}
 			}
 		}
 
 	case MessageTypeNewTx:
 		if tx, ok := message.Content.(*Transaction); ok {
-			fmt.Printf("Received new transaction from %s\n", message.From)
+			fmt.Printf("Received new transaction from %s: %s -> %s (%.2f)\n",
+				message.From, tx.Sender, tx.Receiver, tx.Amount)
 			// Add transaction to pool and forward to other peers
-			pn.BroadcastTransaction(tx)
+			if pn.blockchain != nil {
+				if err := pn.blockchain.Mempool.Add(*tx); err != nil {
+					if !errors.Is(err, ErrDuplicateTransaction) {
+						fmt.Printf("Dropping transaction from %s: %v\n", message.From, err)
+					}
+					return
+				}
+			}
+			pn.forwardMessage(message, peer)
 		}
 
-	case MessageTypeBlockchain:
-		// Handle blockchain request
-		if pn.blockchain != nil {
-			response := BlockchainMessage{
-				Type:    MessageTypeBlockchainResponse,
-				Content: pn.blockchain,
-				From:    pn.MyAddress,
-				To:      message.From,
-			}
-			json.NewEncoder(conn).Encode(response)
+	case MessageTypeGetHeaders:
+		if request, ok := message.Content.(*GetHeadersPayload); ok && pn.blockchain != nil {
+			pn.handleGetHeaders(request, peer)
 		}
 
-	case MessageTypeBlockchainResponse:
-		// Handle received blockchain
-		if blockchain, ok := message.Content.(*Blockchain); ok {
-			fmt.Printf("Received blockchain from %s\n", message.From)
-			// Validate and potentially update local blockchain
-			if pn.blockchain == nil || len(blockchain.Blocks) > len(pn.blockchain.Blocks) {
-				if blockchain.IsValid() {
-					pn.blockchain = blockchain
-				}
-			}
+	case MessageTypeHeaders:
+		if headers, ok := message.Content.(*[]BlockHeader); ok && pn.blockchain != nil {
+			pn.handleHeaders(*headers, peer)
+		}
+
+	case MessageTypeGetBlocks:
+		if request, ok := message.Content.(*GetBlocksPayload); ok && pn.blockchain != nil {
+			pn.handleGetBlocks(request, peer)
+		}
+
+	case MessageTypeBlocks:
+		if blocks, ok := message.Content.(*[]*Block); ok && pn.blockchain != nil {
+			pn.handleBlocks(*blocks, peer)
 		}
 
 	case MessageTypeIPFSBackup:
 		// Handle IPFS backup hash
-		if hash, ok := message.Content.(string); ok {
+		if hashPtr, ok := message.Content.(*string); ok {
+			hash := *hashPtr
 			fmt.Printf("Received blockchain backup hash from %s: %s\n", message.From, hash)
 
 			if pn.blockchain != nil {
-				// Restore from IPFS and validate
-				tempBlocks, err := pn.blockchain.ipfsHandler.RetrieveBlockchain(hash)
-				if err != nil {
-					fmt.Printf("Error retrieving blockchain from IPFS: %v\n", err)
-					return
-				}
-
-				// Only restore if the received blockchain is longer
-				if len(tempBlocks) > len(pn.blockchain.Blocks) {
-					err = pn.blockchain.RestoreFromIPFS(hash)
-					if err != nil {
-						fmt.Printf("Error restoring blockchain from IPFS: %v\n", err)
-						return
-					}
-					fmt.Printf("Successfully restored blockchain from IPFS hash: %s\n", hash)
+				// Add the backed up blocks; the chain with the most work wins
+				if err := pn.blockchain.RestoreFromIPFS(hash); err != nil {
+					fmt.Printf("Error restoring blockchain from IPFS: %v\n", err)
 				}
 			}
 		}
 	}
 }
 
-// BroadcastNewBlock broadcasts a new block to all peers
-func (pn *PeerNetwork) BroadcastNewBlock(block *Block) {
-	message := BlockchainMessage{
-		Type:    MessageTypeNewBlock,
-		Content: block,
+// newGossipMessage creates a message originating from this node with a
+// fresh gossip ID and the default TTL
+func (pn *PeerNetwork) newGossipMessage(messageType MessageType, content interface{}) BlockchainMessage {
+	return BlockchainMessage{
+		Type:    messageType,
+		Content: content,
 		From:    pn.MyAddress,
+		ID:      ComputeMessageID(messageType, pn.MyAddress, content),
+		Origin:  pn.MyAddress,
+		TTL:     DefaultGossipTTL,
 	}
-	pn.BroadcastMessage(string(message.Type), message)
+}
+
+// BroadcastNewBlock broadcasts a new block to all peers
+func (pn *PeerNetwork) BroadcastNewBlock(block *Block) {
+	pn.BroadcastMessage(pn.newGossipMessage(MessageTypeNewBlock, block))
 }
 
 // BroadcastTransaction broadcasts a new transaction to all peers
 func (pn *PeerNetwork) BroadcastTransaction(tx *Transaction) {
-	message := BlockchainMessage{
-		Type:    MessageTypeNewTx,
-		Content: tx,
-		From:    pn.MyAddress,
+	pn.BroadcastMessage(pn.newGossipMessage(MessageTypeNewTx, tx))
+}
+
+// BroadcastMessage sends a message to all connected peers. Gossip messages
+// are marked as seen so that copies flooded back to us are ignored.
+func (pn *PeerNetwork) BroadcastMessage(message BlockchainMessage) {
+	if message.ID != "" {
+		pn.seen.MarkSeen(message.ID)
 	}
-	pn.BroadcastMessage(string(message.Type), message)
+	pn.sendToAll(message, nil)
 }
 
-// BroadcastMessage sends a message to all connected peers
-func (pn *PeerNetwork) BroadcastMessage(messageType string, content interface{}) {
-	pn.mutex.RLock()
-	defer pn.mutex.RUnlock()
+// forwardMessage relays a gossip message to every peer except the one it
+// was received from, as long as its TTL allows another hop
+func (pn *PeerNetwork) forwardMessage(message BlockchainMessage, from *PeerConnection) {
+	if message.ID == "" || message.TTL <= 1 {
+		return
+	}
+
+	message.TTL--
+	message.From = pn.MyAddress
+	pn.sendToAll(message, from)
+}
 
+// sendToAll queues a message for all connected peers except exclude
+func (pn *PeerNetwork) sendToAll(message BlockchainMessage, exclude *PeerConnection) {
+	pn.mutex.RLock()
+	peers := make([]*PeerConnection, 0, len(pn.Peers))
 	for _, peer := range pn.Peers {
-		go func(conn net.Conn) {
-			if err := json.NewEncoder(conn).Encode(content); err != nil {
-				fmt.Printf("Error broadcasting to %s: %v\n", conn.RemoteAddr(), err)
-			}
-		}(peer.Conn)
+		if peer != exclude {
+			peers = append(peers, peer)
+		}
+	}
+	pn.mutex.RUnlock()
+
+	for _, peer := range peers {
+		if err := peer.Send(message); err != nil {
+			fmt.Printf("Error broadcasting to %s: %v\n", peer.Address, err)
+		}
 	}
 }
 
 // New method for broadcasting IPFS backup
 func (pn *PeerNetwork) BroadcastIPFSBackup(hash string) {
-	message := BlockchainMessage{
-		Type:    MessageTypeIPFSBackup,
-		Content: hash,
-		From:    pn.MyAddress,
+	pn.BroadcastMessage(pn.newGossipMessage(MessageTypeIPFSBackup, hash))
+}
+
+// SendToPeer sends a message to the peer listening on the given address
+func (pn *PeerNetwork) SendToPeer(peerAddr string, message BlockchainMessage) error {
+	peer := pn.peerByAddress(peerAddr)
+	if peer == nil {
+		return fmt.Errorf("peer %s not connected", peerAddr)
 	}
-	pn.BroadcastMessage(string(message.Type), message)
+
+	return peer.Send(message)
 }
 
-// SendToPeer sends a message to a specific peer
-func (pn *PeerNetwork) SendToPeer(peerAddr string, messageType string, content interface{}) error {
+// peerByAddress finds a connected peer by its advertised listen address
+func (pn *PeerNetwork) peerByAddress(peerAddr string) *PeerConnection {
 	pn.mutex.RLock()
-	peer, exists := pn.Peers[peerAddr]
-	pn.mutex.RUnlock()
+	defer pn.mutex.RUnlock()
 
-	if !exists {
-		return fmt.Errorf("peer %s not connected", peerAddr)
+	for _, peer := range pn.Peers {
+		if peer.Address == peerAddr {
+			return peer
+		}
 	}
-
-	return json.NewEncoder(peer.Conn).Encode(content)
+	return nil
 }
 
 // GetConnectedPeers returns a list of connected peer addresses
@@ -303,17 +419,15 @@ func (pn *PeerNetwork) GetConnectedPeers() []string {
 	defer pn.mutex.RUnlock()
 
 	peers := make([]string, 0, len(pn.Peers))
-	for addr := range pn.Peers {
-		peers = append(peers, addr)
+	for _, peer := range pn.Peers {
+		peers = append(peers, peer.Address)
 	}
 	return peers
 }
 
 // IsConnected checks if a specific peer is connected
 func (pn *PeerNetwork) IsConnected(peerAddr string) bool {
-	pn.mutex.RLock()
-	defer pn.mutex.RUnlock()
-	return pn.isConnected[peerAddr]
+	return pn.peerByAddress(peerAddr) != nil
 }
 
 // SetBlockchain sets the blockchain reference
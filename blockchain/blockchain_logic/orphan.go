@@ -0,0 +1,113 @@
+// orphan.go
+package blockchain_logic
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// maxOrphanBlocks caps the number of blocks waiting for their parent
+	maxOrphanBlocks = 100
+	// orphanExpiry is how long a block may wait for its parent
+	orphanExpiry = 10 * time.Minute
+)
+
+// ErrOrphanBlock is returned when a block's parent is not known yet. The
+// block is kept and connected once the parent arrives.
+var ErrOrphanBlock = errors.New("block has an unknown parent")
+
+// orphanBlock is a block waiting for its parent
+type orphanBlock struct {
+	block *Block
+	added time.Time
+}
+
+// orphanPool holds blocks whose parent is unknown, keyed by the missing
+// parent hash. It is guarded by the blockchain lock.
+type orphanPool struct {
+	byHash   map[string]*orphanBlock
+	byParent map[string][]*orphanBlock
+}
+
+// newOrphanPool creates an empty orphan pool
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		byHash:   make(map[string]*orphanBlock),
+		byParent: make(map[string][]*orphanBlock),
+	}
+}
+
+// add stores an orphan, dropping expired orphans and, if the pool is full,
+// the oldest one
+func (op *orphanPool) add(block *Block) {
+	if _, exists := op.byHash[block.Hash]; exists {
+		return
+	}
+
+	op.expire(time.Now())
+	if len(op.byHash) >= maxOrphanBlocks {
+		var oldest *orphanBlock
+		for _, orphan := range op.byHash {
+			if oldest == nil || orphan.added.Before(oldest.added) {
+				oldest = orphan
+			}
+		}
+		op.remove(oldest)
+	}
+
+	orphan := &orphanBlock{block: block, added: time.Now()}
+	op.byHash[block.Hash] = orphan
+	op.byParent[block.PrevHash] = append(op.byParent[block.PrevHash], orphan)
+}
+
+// expire removes orphans older than orphanExpiry
+func (op *orphanPool) expire(now time.Time) {
+	for _, orphan := range op.byHash {
+		if now.Sub(orphan.added) > orphanExpiry {
+			op.remove(orphan)
+		}
+	}
+}
+
+// remove deletes an orphan from both indexes
+func (op *orphanPool) remove(orphan *orphanBlock) {
+	delete(op.byHash, orphan.block.Hash)
+
+	siblings := op.byParent[orphan.block.PrevHash]
+	for i, sibling := range siblings {
+		if sibling == orphan {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(op.byParent, orphan.block.PrevHash)
+	} else {
+		op.byParent[orphan.block.PrevHash] = siblings
+	}
+}
+
+// takeChildren removes and returns the orphans waiting for the given parent
+func (op *orphanPool) takeChildren(parentHash string) []*Block {
+	children := op.byParent[parentHash]
+	blocks := make([]*Block, 0, len(children))
+	for _, child := range children {
+		delete(op.byHash, child.block.Hash)
+		blocks = append(blocks, child.block)
+	}
+	delete(op.byParent, parentHash)
+	return blocks
+}
+
+// missingAncestor follows an orphan's parents through the pool and returns
+// the hash of the first block that is not in it
+func (op *orphanPool) missingAncestor(hash string) string {
+	for {
+		orphan, exists := op.byHash[hash]
+		if !exists {
+			return hash
+		}
+		hash = orphan.block.PrevHash
+	}
+}
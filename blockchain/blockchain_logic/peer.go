@@ -0,0 +1,122 @@
+// peer.go
+package blockchain_logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// peerSendQueueSize bounds the number of messages queued for one peer
+	peerSendQueueSize = 256
+	// peerSendTimeout is how long a sender waits for room in a full queue
+	// before the peer is considered too slow and dropped
+	peerSendTimeout = 2 * time.Second
+	// peerWriteTimeout is the write deadline for a single message
+	peerWriteTimeout = 10 * time.Second
+)
+
+// ErrPeerClosed is returned when sending to a peer whose connection is closed
+var ErrPeerClosed = errors.New("peer connection closed")
+
+// PeerConnection represents a connection to a peer. Address is the listen
+// address the peer advertised during the handshake. All writes go through a
+// single writer goroutine fed by a bounded queue, so messages never
+// interleave on the wire.
+type PeerConnection struct {
+	Address    string
+	Conn       net.Conn
+	NodeID     string
+	Version    int
+	BestHeight int
+	Outbound   bool // True if we dialed the peer
+
+	// wantedOrphan is the orphan whose ancestors were last requested from
+	// the peer. Only the peer's reader uses it.
+	wantedOrphan string
+
+	sendQueue chan BlockchainMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newPeerConnection wraps a connection. The writer goroutine is started with
+// start once the handshake has filled in the peer's description.
+func newPeerConnection(conn net.Conn, outbound bool) *PeerConnection {
+	return &PeerConnection{
+		Address:   conn.RemoteAddr().String(),
+		Conn:      conn,
+		Outbound:  outbound,
+		sendQueue: make(chan BlockchainMessage, peerSendQueueSize),
+		closed:    make(chan struct{}),
+	}
+}
+
+// start starts the writer goroutine. The peer's fields must not change
+// afterwards, since the writer reads them without a lock.
+func (pc *PeerConnection) start() {
+	go pc.writeLoop()
+}
+
+// Send queues a message for the peer. When the queue stays full for longer
+// than peerSendTimeout the peer is dropped.
+func (pc *PeerConnection) Send(message BlockchainMessage) error {
+	select {
+	case <-pc.closed:
+		return ErrPeerClosed
+	default:
+	}
+
+	select {
+	case pc.sendQueue <- message:
+		return nil
+	case <-pc.closed:
+		return ErrPeerClosed
+	default:
+	}
+
+	// Queue is full: apply backpressure for a bounded time
+	timer := time.NewTimer(peerSendTimeout)
+	defer timer.Stop()
+
+	select {
+	case pc.sendQueue <- message:
+		return nil
+	case <-pc.closed:
+		return ErrPeerClosed
+	case <-timer.C:
+		pc.Close()
+		return fmt.Errorf("send queue to %s full, dropping peer", pc.Address)
+	}
+}
+
+// writeLoop writes queued messages to the connection until it is closed
+func (pc *PeerConnection) writeLoop() {
+	encoder := json.NewEncoder(pc.Conn)
+	for {
+		select {
+		case <-pc.closed:
+			return
+		case message := <-pc.sendQueue:
+			pc.Conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+			if err := encoder.Encode(message); err != nil {
+				fmt.Printf("Error writing to %s: %v\n", pc.Address, err)
+				pc.Close()
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection and stops the writer. It is safe to call
+// more than once.
+func (pc *PeerConnection) Close() {
+	pc.closeOnce.Do(func() {
+		close(pc.closed)
+		pc.Conn.Close()
+	})
+}
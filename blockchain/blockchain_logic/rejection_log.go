@@ -0,0 +1,159 @@
+// rejection_log.go
+package blockchain_logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// rejectionLogFile is the rejection log in the data directory, one JSON
+	// object per line
+	rejectionLogFile = "rejected.jsonl"
+	// maxRecentRejections caps the rejections kept in memory
+	maxRecentRejections = 1000
+	// maxRejectionLogSize is the size at which the log file is rotated. The
+	// previous file is kept with a .1 suffix, replacing any older one.
+	maxRejectionLogSize = 10 << 20
+)
+
+// RejectedTransaction is a transaction the validator model refused or
+// flagged for review, with the result that explains why, kept for compliance
+// review
+type RejectedTransaction struct {
+	TxID        string            `json:"tx_id"`
+	Transaction Transaction       `json:"transaction"`
+	Result      *ValidationResult `json:"result"`
+	ModelHash   string            `json:"model_hash"`
+	RejectedAt  int64             `json:"rejected_at"`
+}
+
+// RejectionLog records rejected and flagged transactions. The most recent
+// ones are kept in memory; with a file, every rejection is also appended to
+// it. A transaction validated again with the same outcome, as pending
+// transactions are on every mining round, is only recorded once while it is
+// among the recent rejections.
+type RejectionLog struct {
+	path     string
+	file     *os.File
+	size     int64 // Bytes in the current file
+	recent   []RejectedTransaction
+	recorded map[string]bool // Decisions in recent, see rejectionKey
+	mutex    sync.Mutex
+}
+
+// OpenRejectionLog opens or creates the rejection log at path. An empty path
+// keeps the log in memory only.
+func OpenRejectionLog(path string) (*RejectionLog, error) {
+	rl := &RejectionLog{path: path, recorded: make(map[string]bool)}
+	if path == "" {
+		return rl, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open rejection log: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open rejection log: %v", err)
+	}
+	rl.file = file
+	rl.size = info.Size()
+	return rl, nil
+}
+
+// rejectionKey identifies a decision on a transaction
+func rejectionKey(entry *RejectedTransaction) string {
+	return entry.TxID + ":" + entry.Result.Band + ":" + entry.ModelHash
+}
+
+// Record adds a rejected or flagged transaction to the log
+func (rl *RejectionLog) Record(tx Transaction, result *ValidationResult, modelHash string) error {
+	entry := RejectedTransaction{
+		TxID:        tx.Hash(),
+		Transaction: tx,
+		Result:      result,
+		ModelHash:   modelHash,
+		RejectedAt:  time.Now().Unix(),
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	key := rejectionKey(&entry)
+	if rl.recorded[key] {
+		return nil
+	}
+	rl.recorded[key] = true
+	rl.recent = append(rl.recent, entry)
+	if len(rl.recent) > maxRecentRejections {
+		for i := range rl.recent[:len(rl.recent)-maxRecentRejections] {
+			delete(rl.recorded, rejectionKey(&rl.recent[i]))
+		}
+		rl.recent = rl.recent[len(rl.recent)-maxRecentRejections:]
+	}
+
+	if rl.file == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode rejection: %v", err)
+	}
+	if rl.size+int64(len(data))+1 > maxRejectionLogSize && rl.size > 0 {
+		if err := rl.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := rl.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write rejection log: %v", err)
+	}
+	rl.size += int64(len(data)) + 1
+	return nil
+}
+
+// rotate moves the log file aside and starts a new one. The caller must hold
+// the lock.
+func (rl *RejectionLog) rotate() error {
+	if err := rl.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate rejection log: %v", err)
+	}
+	rl.file = nil
+	if err := os.Rename(rl.path, rl.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate rejection log: %v", err)
+	}
+	file, err := os.OpenFile(rl.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to rotate rejection log: %v", err)
+	}
+	rl.file = file
+	rl.size = 0
+	return nil
+}
+
+// Recent returns the rejections kept in memory, oldest first
+func (rl *RejectionLog) Recent() []RejectedTransaction {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	recent := make([]RejectedTransaction, len(rl.recent))
+	copy(recent, rl.recent)
+	return recent
+}
+
+// Close closes the log file
+func (rl *RejectionLog) Close() error {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.file == nil {
+		return nil
+	}
+	err := rl.file.Close()
+	rl.file = nil
+	return err
+}
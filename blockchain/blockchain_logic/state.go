@@ -0,0 +1,123 @@
+// state.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WorldState tracks the balance and next expected nonce of every address
+type WorldState struct {
+	balances map[string]float64
+	nonces   map[string]uint64
+}
+
+// NewWorldState creates an empty world state
+func NewWorldState() *WorldState {
+	return &WorldState{
+		balances: make(map[string]float64),
+		nonces:   make(map[string]uint64),
+	}
+}
+
+// Copy returns an independent copy of the state
+func (ws *WorldState) Copy() *WorldState {
+	copied := NewWorldState()
+	for addr, balance := range ws.balances {
+		copied.balances[addr] = balance
+	}
+	for addr, nonce := range ws.nonces {
+		copied.nonces[addr] = nonce
+	}
+	return copied
+}
+
+// Balance returns the balance of an address
+func (ws *WorldState) Balance(addr string) float64 {
+	return ws.balances[addr]
+}
+
+// Nonce returns the nonce the next transaction from an address must use
+func (ws *WorldState) Nonce(addr string) uint64 {
+	return ws.nonces[addr]
+}
+
+// CheckTransaction reports whether the transaction could be applied, rejecting
+// overspends and transactions whose nonce was already used or is out of order
+func (ws *WorldState) CheckTransaction(tx Transaction) error {
+	if tx.Amount <= 0 {
+		return fmt.Errorf("invalid amount %.2f", tx.Amount)
+	}
+	if expected := ws.nonces[tx.Sender]; tx.Nonce != expected {
+		if tx.Nonce < expected {
+			return fmt.Errorf("replayed transaction from %s: nonce %d already used", tx.Sender, tx.Nonce)
+		}
+		return fmt.Errorf("out of order transaction from %s: nonce %d, expected %d", tx.Sender, tx.Nonce, expected)
+	}
+	if balance := ws.balances[tx.Sender]; balance < tx.Amount {
+		return fmt.Errorf("insufficient balance for %s: has %.2f, needs %.2f", tx.Sender, balance, tx.Amount)
+	}
+	return nil
+}
+
+// ApplyTransaction transfers the amount from sender to receiver
+func (ws *WorldState) ApplyTransaction(tx Transaction) error {
+	if err := ws.CheckTransaction(tx); err != nil {
+		return err
+	}
+
+	ws.balances[tx.Sender] -= tx.Amount
+	ws.balances[tx.Receiver] += tx.Amount
+	ws.nonces[tx.Sender]++
+	return nil
+}
+
+// ApplyBlock applies all transactions of a block. The genesis block instead
+// credits its allocation transactions, which have no sender.
+func (ws *WorldState) ApplyBlock(block *Block) error {
+	for i, tx := range block.Transactions {
+		if block.Index == 0 {
+			if tx.Sender != "" || tx.Amount <= 0 {
+				return fmt.Errorf("invalid genesis allocation %d", i)
+			}
+			ws.balances[tx.Receiver] += tx.Amount
+			continue
+		}
+
+		if err := ws.ApplyTransaction(tx); err != nil {
+			return fmt.Errorf("transaction %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// buildState replays a chain from its genesis block
+func buildState(blocks []*Block) (*WorldState, error) {
+	state := NewWorldState()
+	for _, block := range blocks {
+		if err := state.ApplyBlock(block); err != nil {
+			return nil, fmt.Errorf("block %d: %v", block.Index, err)
+		}
+	}
+	return state, nil
+}
+
+// genesisAllocations turns a genesis allocation into the transactions stored
+// in the genesis block, sorted by address so every node builds the same block
+func genesisAllocations(alloc map[string]float64) []Transaction {
+	addrs := make([]string, 0, len(alloc))
+	for addr := range alloc {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	transactions := make([]Transaction, 0, len(addrs))
+	for _, addr := range addrs {
+		transactions = append(transactions, Transaction{
+			Receiver:  addr,
+			Amount:    alloc[addr],
+			Timestamp: GenesisTimestamp,
+		})
+	}
+	return transactions
+}
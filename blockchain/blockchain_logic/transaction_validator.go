@@ -0,0 +1,146 @@
+// transaction_validator.go
+package blockchain_logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Validator kinds, as used in model files and on the command line
+const (
+	ValidatorLogistic        = "logistic"
+	ValidatorMLP             = "mlp"
+	ValidatorIsolationForest = "isolation-forest"
+)
+
+// TransactionValidator is a model that decides whether a transaction looks
+// legitimate. Validate must be deterministic across nodes, since blocks are
+// rejected when it refuses one of their transactions.
+type TransactionValidator interface {
+	// Train fits the model on a labeled CSV and reports how it performs
+	Train(filepath string) (*TrainingReport, error)
+	// Validate decides whether the transaction is accepted and breaks the
+	// score down by feature
+	Validate(tx Transaction) *ValidationResult
+	// Explain formats the result of Validate for reviewers
+	Explain(tx Transaction) string
+	// Features returns the model inputs extracted from a transaction
+	Features(tx Transaction) []float64
+	// ReferenceFeatures returns the sorted values of each feature over the
+	// training data, or nil for a model saved without them
+	ReferenceFeatures() [][]float64
+	// ModelHash identifies the trained model
+	ModelHash() string
+	// Save writes the trained model, Load reads one written by Save
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+}
+
+// OnlineValidator is a TransactionValidator that keeps learning from the
+// transactions of confirmed blocks. Learning must be deterministic, since
+// every node derives the next committed model from the same blocks.
+type OnlineValidator interface {
+	TransactionValidator
+	// Learn updates the model with the transactions of a confirmed block
+	Learn(transactions []Transaction)
+	// Clone returns an independent copy of the model
+	Clone() OnlineValidator
+}
+
+// NewTransactionValidator creates an untrained validator of the given kind
+func NewTransactionValidator(kind string) (TransactionValidator, error) {
+	switch kind {
+	case ValidatorLogistic, "":
+		return NewMLTransactionValidator(), nil
+	case ValidatorMLP:
+		return NewMLPValidator(), nil
+	case ValidatorIsolationForest:
+		return NewIsolationForestValidator(), nil
+	}
+	return nil, fmt.Errorf("unknown validator %q", kind)
+}
+
+// evaluate scores labeled samples with the validator's decisions
+func evaluate(validator TransactionValidator, samples []trainingSample) ClassificationMetrics {
+	invalid := make([]bool, len(samples))
+	rejected := make([]bool, len(samples))
+	scores := make([]float64, len(samples))
+	for i, sample := range samples {
+		result := validator.Validate(Transaction{
+			Sender:   sample.sender,
+			Receiver: sample.receiver,
+			Amount:   sample.amount,
+		})
+		invalid[i] = !sample.valid
+		rejected[i] = !result.Valid
+		scores[i] = 1 - result.Probability
+	}
+	return computeMetrics(invalid, rejected, scores)
+}
+
+// newTrainingReport evaluates a freshly trained validator on the validation
+// samples, or on the training samples if there are none, and logs the report
+func newTrainingReport(validator TransactionValidator, labelSource string, training, validation []trainingSample) *TrainingReport {
+	evaluated := validation
+	if len(evaluated) == 0 {
+		evaluated = training
+	}
+	report := &TrainingReport{
+		LabelSource:       labelSource,
+		TrainingSamples:   len(training),
+		ValidationSamples: len(validation),
+		Metrics:           evaluate(validator, evaluated),
+	}
+	fmt.Printf("\nModel Evaluation:\n%s", report)
+	return report
+}
+
+// SaveModelFile writes a trained model to a file
+func SaveModelFile(validator TransactionValidator, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create model file: %v", err)
+	}
+	if err := validator.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadModelFile creates a validator of the kind recorded in a model file
+// written by Save
+func LoadModelFile(path string) (TransactionValidator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open model file: %v", err)
+	}
+
+	var header struct {
+		ModelType string `json:"model_type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("failed to read model: %v", err)
+	}
+	validator, err := NewTransactionValidator(header.ModelType)
+	if err != nil {
+		return nil, err
+	}
+	if err := validator.Load(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return validator, nil
+}
+
+// loadModelFile loads a model file into an existing validator
+func loadModelFile(validator TransactionValidator, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open model file: %v", err)
+	}
+	defer file.Close()
+	return validator.Load(file)
+}
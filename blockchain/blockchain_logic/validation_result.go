@@ -0,0 +1,148 @@
+// validation_result.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxTopFactors is the number of features named as the main reasons for a
+// decision
+const maxTopFactors = 3
+
+// Decision bands of a validation result
+const (
+	BandAccept = "accept"
+	BandFlag   = "flag" // Accepted, but flagged for review
+	BandReject = "reject"
+)
+
+// FeatureContribution is how much one feature moved a transaction's score
+type FeatureContribution struct {
+	Feature      string  `json:"feature"`
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`       // Isolation forests: average splits taken on the feature
+	Contribution float64 `json:"contribution"` // Weight x value, added to the logit. Isolation forests: splits above the training average, scaled by the average path length.
+}
+
+// ValidationResult is a validator's decision on a transaction and the
+// features behind it. Values come from the fixed-point model, so every node
+// computes the same result.
+type ValidationResult struct {
+	Valid         bool                  `json:"valid"`
+	Band          string                `json:"band"`        // BandAccept, BandFlag or BandReject
+	Probability   float64               `json:"probability"` // Probability that the transaction is valid
+	Threshold     float64               `json:"threshold"`   // Transactions scoring below it are rejected
+	Bias          float64               `json:"bias"`
+	Contributions []FeatureContribution `json:"contributions"`
+	TopFactors    []string              `json:"top_factors"` // Features that pushed hardest toward the decision
+	Reason        string                `json:"reason"`
+	Velocity      *VelocityFeatures     `json:"velocity,omitempty"` // Set when velocity checks are enabled
+}
+
+// newValidationResult decides on a Q16 probability of being valid and
+// explains it by a linear model of the logit: bias plus weight x value for
+// each feature
+func newValidationResult(score int64, features, weights []int64, bias int64) *ValidationResult {
+	contributions := make([]FeatureContribution, len(features))
+	for i, feature := range features {
+		contributions[i] = FeatureContribution{
+			Feature:      featureNames[i],
+			Value:        fromFixed(feature),
+			Weight:       fromFixed(weights[i]),
+			Contribution: fromFixed(mulDiv(feature, weights[i], fixedOne)),
+		}
+	}
+	band := BandAccept
+	if score < fixedHalf {
+		band = BandReject
+	}
+	return explainDecision(score, fixedHalf, band, contributions, bias)
+}
+
+// explainDecision builds the result of a decision already placed in a band.
+// The top factors are the features that pushed the score furthest toward
+// the decision: up for accepted, down for flagged and rejected transactions.
+func explainDecision(score, threshold int64, band string, contributions []FeatureContribution, bias int64) *ValidationResult {
+	result := &ValidationResult{
+		Valid:         band != BandReject,
+		Band:          band,
+		Probability:   fromFixed(score),
+		Threshold:     fromFixed(threshold),
+		Bias:          fromFixed(bias),
+		Contributions: contributions,
+	}
+
+	up := band == BandAccept
+	ranked := make([]FeatureContribution, len(contributions))
+	copy(ranked, contributions)
+	sort.SliceStable(ranked, func(a, b int) bool {
+		if up {
+			return ranked[a].Contribution > ranked[b].Contribution
+		}
+		return ranked[a].Contribution < ranked[b].Contribution
+	})
+	factors := make([]string, 0, maxTopFactors)
+	for _, c := range ranked {
+		if len(factors) == maxTopFactors || (up && c.Contribution <= 0) || (!up && c.Contribution >= 0) {
+			break
+		}
+		result.TopFactors = append(result.TopFactors, c.Feature)
+		factors = append(factors, fmt.Sprintf("%s (%+.4f)", c.Feature, c.Contribution))
+	}
+
+	switch {
+	case band == BandAccept:
+		result.Reason = "Transaction appears valid"
+	case len(factors) == 0:
+		result.Reason = "Unusual transaction pattern: low baseline score"
+	default:
+		result.Reason = "Unusual transaction pattern: " + strings.Join(factors, ", ")
+	}
+	if band == BandFlag {
+		result.Reason += ", flagged for review"
+	}
+	return result
+}
+
+// addVelocity attaches the velocity features of the transaction. An
+// accepted transaction that exceeds a velocity limit is flagged for review;
+// velocity never rejects, since it depends on the local mempool.
+func (r *ValidationResult) addVelocity(vf *VelocityFeatures) {
+	r.Velocity = vf
+	if len(vf.Alerts) == 0 {
+		return
+	}
+	alerts := strings.Join(vf.Alerts, ", ")
+	if r.Band == BandAccept {
+		r.Band = BandFlag
+		r.Reason = "Unusual transaction velocity: " + alerts + ", flagged for review"
+	} else {
+		r.Reason += "; unusual velocity: " + alerts
+	}
+}
+
+// String formats the result for logs and reviewers
+func (r *ValidationResult) String() string {
+	var b strings.Builder
+	decision := "accepted"
+	switch {
+	case r.Band == BandFlag:
+		decision = "flagged"
+	case r.Band == BandReject, r.Band == "" && !r.Valid:
+		decision = "rejected"
+	}
+	fmt.Fprintf(&b, "%s: probability valid %.4f, threshold %.4f\n", decision, r.Probability, r.Threshold)
+	fmt.Fprintf(&b, "  %-20s %10s %10s %12s\n", "feature", "value", "weight", "contribution")
+	for _, c := range r.Contributions {
+		fmt.Fprintf(&b, "  %-20s %10.4f %10.4f %+12.4f\n", c.Feature, c.Value, c.Weight, c.Contribution)
+	}
+	fmt.Fprintf(&b, "  %-20s %10s %10s %+12.4f\n", "bias", "", "", r.Bias)
+	if vf := r.Velocity; vf != nil {
+		fmt.Fprintf(&b, "  velocity: %.2f tx/block, %.2f sent, fan-out %d, fan-in %d, new in window %t\n",
+			vf.SenderTxRate, vf.SenderWindowAmount, vf.SenderFanOut, vf.ReceiverFanIn, vf.NewInWindow)
+	}
+	fmt.Fprintf(&b, "  reason: %s", r.Reason)
+	return b.String()
+}
@@ -4,115 +4,167 @@ import (
 	"encoding/csv"
 	"fmt"
 	"math"
+	"math/rand"
 	"os"
 	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultValidationSplit is the fraction of training rows held out for
+	// evaluation
+	DefaultValidationSplit = 0.2
+	// trainingSeed seeds the train/validation shuffle
+	trainingSeed = 1
 )
 
 // MLTransactionValidator represents our ML model
 type MLTransactionValidator struct {
-	weights        []float64
-	bias           float64
-	senderCounts   map[string]int
-	receiverCounts map[string]int
-	meanAmount     float64
-	stdAmount      float64
-	maxAmount      float64
-	minAmount      float64
-	// New fields for pattern recognition
-	senderAverages   map[string]float64
-	receiverAverages map[string]float64
+	featureStats
+	weights []float64
+	bias    float64
+	// ValidationSplit is the fraction of the training data held out to
+	// evaluate the model
+	ValidationSplit float64
+	// Fixed-point copy of the model used for validation decisions
+	fixed *fixedModel
 }
 
 func NewMLTransactionValidator() *MLTransactionValidator {
-	return &MLTransactionValidator{
-		weights:          make([]float64, 5), // Increased features
-		senderCounts:     make(map[string]int),
-		receiverCounts:   make(map[string]int),
-		senderAverages:   make(map[string]float64),
-		receiverAverages: make(map[string]float64),
+	mv := &MLTransactionValidator{
+		featureStats:    newFeatureStats(),
+		weights:         make([]float64, numFeatures), // Increased features
+		ValidationSplit: DefaultValidationSplit,
 	}
+	mv.fixed = mv.params().quantize()
+	return mv
+}
+
+// trainingSample is one labeled row of the training data
+type trainingSample struct {
+	sender   string
+	receiver string
+	amount   float64
+	valid    bool
 }
 
-func (mv *MLTransactionValidator) Train(filepath string) error {
-	// Read training data
+// readTrainingData reads a CSV with Sender, Receiver and Amount columns and
+// an optional Label column, where 1 marks a valid and 0 an invalid
+// transaction. Without labels, transactions with an amount outside (0, 1000]
+// are labeled invalid.
+// Account names are mapped to their dev wallet addresses, the senders and
+// receivers of the signed transactions the model will see.
+func readTrainingData(filepath string) ([]trainingSample, string, error) {
 	file, err := os.Open(filepath)
 	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
+		return nil, "", fmt.Errorf("error opening file: %v", err)
 	}
 	defer file.Close()
 
 	reader := csv.NewReader(file)
 	records, err := reader.ReadAll()
 	if err != nil {
-		return fmt.Errorf("error reading CSV: %v", err)
+		return nil, "", fmt.Errorf("error reading CSV: %v", err)
+	}
+	if len(records) < 2 {
+		return nil, "", fmt.Errorf("no training data in %s", filepath)
+	}
+
+	columns := map[string]int{"Label": -1}
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Sender", "Receiver", "Amount"} {
+		if _, ok := columns[name]; !ok {
+			return nil, "", fmt.Errorf("missing %s column", name)
+		}
+	}
+	labelColumn := columns["Label"]
+	labelSource := "amount rule"
+	if labelColumn >= 0 {
+		labelSource = "label column"
 	}
 
 	// Skip header
-	records = records[1:]
-
-	// First pass: collect statistics
-	var sumAmount float64
-	var amounts []float64
-	senderTotals := make(map[string]float64)
-	receiverTotals := make(map[string]float64)
-
-	mv.maxAmount = 0
-	mv.minAmount = math.MaxFloat64
-
-	for _, record := range records {
-		amount, _ := strconv.ParseFloat(record[2], 64)
-		sender, receiver := record[0], record[1]
-
-		// Update statistics
-		sumAmount += amount
-		amounts = append(amounts, amount)
-		mv.senderCounts[sender]++
-		mv.receiverCounts[receiver]++
-		senderTotals[sender] += amount
-		receiverTotals[receiver] += amount
-
-		// Update min/max
-		if amount > mv.maxAmount {
-			mv.maxAmount = amount
+	addresses := make(map[string]string)
+	address := func(name string) string {
+		if _, ok := addresses[name]; !ok {
+			addresses[name] = DevAddress(name)
+		}
+		return addresses[name]
+	}
+	samples := make([]trainingSample, 0, len(records)-1)
+	for line, record := range records[1:] {
+		amount, err := strconv.ParseFloat(record[columns["Amount"]], 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid amount at line %d: %v", line+2, err)
 		}
-		if amount < mv.minAmount {
-			mv.minAmount = amount
+
+		valid := amount > 0 && amount <= 1000
+		if labelColumn >= 0 {
+			valid, err = parseLabel(record[labelColumn])
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid label at line %d: %v", line+2, err)
+			}
 		}
+
+		samples = append(samples, trainingSample{
+			sender:   address(record[columns["Sender"]]),
+			receiver: address(record[columns["Receiver"]]),
+			amount:   amount,
+			valid:    valid,
+		})
 	}
+	return samples, labelSource, nil
+}
 
-	// Calculate averages
-	for sender, total := range senderTotals {
-		mv.senderAverages[sender] = total / float64(mv.senderCounts[sender])
+// parseLabel parses a Label column value
+func parseLabel(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "valid":
+		return true, nil
+	case "0", "false", "invalid", "fraud":
+		return false, nil
 	}
-	for receiver, total := range receiverTotals {
-		mv.receiverAverages[receiver] = total / float64(mv.receiverCounts[receiver])
+	return false, fmt.Errorf("unknown label %q", value)
+}
+
+// splitSamples shuffles the samples with a fixed seed, so every node gets the
+// same split and model, and holds out the given fraction for validation
+func splitSamples(samples []trainingSample, fraction float64) (train, validation []trainingSample) {
+	shuffled := make([]trainingSample, len(samples))
+	copy(shuffled, samples)
+	rng := rand.New(rand.NewSource(trainingSeed))
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+
+	held := int(float64(len(shuffled)) * fraction)
+	if held <= 0 || held >= len(shuffled) {
+		return shuffled, nil
 	}
+	return shuffled[held:], shuffled[:held]
+}
 
-	// Calculate mean and standard deviation
-	mv.meanAmount = sumAmount / float64(len(records))
-	var sumSquares float64
-	for _, amount := range amounts {
-		diff := amount - mv.meanAmount
-		sumSquares += diff * diff
+// Train fits the model on a labeled CSV, holding out ValidationSplit of the
+// rows, and reports how the model performs on them
+func (mv *MLTransactionValidator) Train(filepath string) (*TrainingReport, error) {
+	samples, labelSource, err := readTrainingData(filepath)
+	if err != nil {
+		return nil, err
 	}
-	mv.stdAmount = math.Sqrt(sumSquares / float64(len(amounts)))
+	training, validation := splitSamples(samples, mv.ValidationSplit)
 
-	fmt.Printf("\nModel Training Statistics:\n")
-	fmt.Printf("Number of transactions: %d\n", len(records))
-	fmt.Printf("Average amount: %.2f\n", mv.meanAmount)
-	fmt.Printf("Standard deviation: %.2f\n", mv.stdAmount)
-	fmt.Printf("Min amount: %.2f\n", mv.minAmount)
-	fmt.Printf("Max amount: %.2f\n", mv.maxAmount)
-	fmt.Printf("Unique senders: %d\n", len(mv.senderCounts))
-	fmt.Printf("Unique receivers: %d\n", len(mv.receiverCounts))
+	mv.fit(training)
 
 	// Train the model using logistic regression
-	mv.trainLogisticRegression(records)
+	mv.trainLogisticRegression(training)
+	mv.fixed = mv.params().quantize()
 
-	return nil
+	return newTrainingReport(mv, labelSource, training, validation), nil
 }
 
-func (mv *MLTransactionValidator) trainLogisticRegression(records [][]string) {
+func (mv *MLTransactionValidator) trainLogisticRegression(samples []trainingSample) {
 	learningRate := 0.01
 	epochs := 100
 
@@ -125,88 +177,59 @@ func (mv *MLTransactionValidator) trainLogisticRegression(records [][]string) {
 	for epoch := 0; epoch < epochs; epoch++ {
 		totalLoss := 0.0
 
-		for _, record := range records {
-			amount, _ := strconv.ParseFloat(record[2], 64)
-			features := mv.extractFeatures(record[0], record[1], amount)
+		for _, sample := range samples {
+			features := mv.extractFeatures(sample.sender, sample.receiver, sample.amount)
 
-			// Define label (1 for valid, 0 for invalid)
-			label := 1.0
-			if amount > 1000 || amount <= 0 {
-				label = 0.0
+			// Label is 1 for valid, 0 for invalid
+			label := 0.0
+			if sample.valid {
+				label = 1.0
 			}
 
 			// Forward pass
 			prediction := mv.predict(features)
 			loss := label - prediction
 
-			// Update weights
+			// Update weights, rounding every product so that it is not fused
+			// into a multiply-add on some platforms only
+			step := float64(learningRate * loss)
 			for i := range mv.weights {
-				mv.weights[i] += learningRate * loss * features[i]
+				mv.weights[i] += float64(step * features[i])
 			}
-			mv.bias += learningRate * loss
+			mv.bias += step
 
 			totalLoss += math.Abs(loss)
 		}
 
 		if epoch%20 == 0 {
-			fmt.Printf("Epoch %d, Average Loss: %.4f\n", epoch, totalLoss/float64(len(records)))
+			fmt.Printf("Epoch %d, Average Loss: %.4f\n", epoch, totalLoss/float64(len(samples)))
 		}
 	}
 }
 
-func (mv *MLTransactionValidator) extractFeatures(sender, receiver string, amount float64) []float64 {
-	// Feature 1: Normalized amount
-	normalizedAmount := (amount - mv.meanAmount) / mv.stdAmount
-
-	// Feature 2: Sender frequency (normalized)
-	senderFreq := float64(mv.senderCounts[sender]) / float64(len(mv.senderCounts))
-
-	// Feature 3: Receiver frequency (normalized)
-	receiverFreq := float64(mv.receiverCounts[receiver]) / float64(len(mv.receiverCounts))
-
-	// Feature 4: Sender's average transaction difference
-	senderAvgDiff := math.Abs(amount-mv.senderAverages[sender]) / mv.maxAmount
-
-	// Feature 5: Receiver's average transaction difference
-	receiverAvgDiff := math.Abs(amount-mv.receiverAverages[receiver]) / mv.maxAmount
-
-	return []float64{
-		normalizedAmount,
-		senderFreq,
-		receiverFreq,
-		senderAvgDiff,
-		receiverAvgDiff,
-	}
-}
-
+// sigmoid is the fixed-point sigmoid used for scoring. math.Exp has
+// platform-specific implementations, so training with it could give each
+// platform a different model and genesis block.
 func sigmoid(x float64) float64 {
-	return 1.0 / (1.0 + math.Exp(-x))
+	return fromFixed(fixedSigmoid(toFixed(x)))
 }
 
 func (mv *MLTransactionValidator) predict(features []float64) float64 {
 	sum := mv.bias
 	for i, feature := range features {
-		sum += feature * mv.weights[i]
+		sum += float64(feature * mv.weights[i])
 	}
 	return sigmoid(sum)
 }
 
-func (mv *MLTransactionValidator) ValidateTransaction(tx Transaction) (bool, float64, string) {
-	features := mv.extractFeatures(tx.Sender, tx.Receiver, tx.Amount)
-	probability := mv.predict(features)
-
-	// Decision making with explanation
-	var reason string
-	if probability < 0.5 {
-		if tx.Amount > 1000 {
-			reason = "Amount exceeds normal transaction range"
-		} else if tx.Amount <= 0 {
-			reason = "Invalid transaction amount"
-		} else {
-			reason = "Unusual transaction pattern detected"
-		}
-		return false, probability, reason
-	}
+// Validate scores a transaction with the fixed-point model, so the decision
+// is the same on every node
+func (mv *MLTransactionValidator) Validate(tx Transaction) *ValidationResult {
+	features := mv.fixed.features(tx.Sender, tx.Receiver, tx.Amount)
+	return newValidationResult(mv.fixed.predict(features), features, mv.fixed.weights, mv.fixed.bias)
+}
 
-	return true, probability, "Transaction appears valid"
+// Explain lists the transaction's features and how each one moves the score
+func (mv *MLTransactionValidator) Explain(tx Transaction) string {
+	return mv.Validate(tx).String()
 }
@@ -0,0 +1,202 @@
+// validator_fixed.go
+package blockchain_logic
+
+import (
+	"math"
+	"math/big"
+)
+
+// ValidatorVersion identifies the feature extraction and fixed-point
+// inference rules. Blocks commit to it so that a change to either is a
+// consensus change.
+const ValidatorVersion = 1
+
+// Scores are computed in Q16 fixed point: a value v is stored as v * 2^16.
+// Integer arithmetic gives the same result on every platform, whereas float
+// results may differ with compiler optimizations such as fused multiply-add.
+const (
+	fixedShift       = 16
+	fixedOne   int64 = 1 << fixedShift
+	fixedHalf  int64 = fixedOne / 2
+	fixedLn2   int64 = 45426 // ln(2) in Q16
+)
+
+// fixedStats are the feature statistics quantized to Q16
+type fixedStats struct {
+	meanAmount       int64
+	stdAmount        int64
+	maxAmount        int64
+	senderCounts     map[string]int64
+	receiverCounts   map[string]int64
+	senderAverages   map[string]int64
+	receiverAverages map[string]int64
+}
+
+// fixedModel is the trained logistic model quantized to Q16, used for all
+// decisions
+type fixedModel struct {
+	fixedStats
+	weights []int64
+	bias    int64
+}
+
+// toFixed converts a float to Q16, saturating at the int64 range. The
+// multiplication by a power of two is exact, so the result only depends on
+// the float's value.
+func toFixed(x float64) int64 {
+	v := math.Round(x * float64(fixedOne))
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v >= math.MaxInt64:
+		return math.MaxInt64
+	case v <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(v)
+}
+
+// fromFixed converts a Q16 value to a float for display
+func fromFixed(x int64) float64 {
+	return float64(x) / float64(fixedOne)
+}
+
+// saturate converts a big integer to int64, saturating at the int64 range
+func saturate(x *big.Int) int64 {
+	if x.IsInt64() {
+		return x.Int64()
+	}
+	if x.Sign() > 0 {
+		return math.MaxInt64
+	}
+	return math.MinInt64
+}
+
+// mulDiv returns a * b / c without intermediate overflow, truncated toward
+// zero. Division by zero yields zero.
+func mulDiv(a, b, c int64) int64 {
+	if c == 0 {
+		return 0
+	}
+	product := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	return saturate(product.Quo(product, big.NewInt(c)))
+}
+
+// fixedAbs returns |x|, saturating for the minimum int64
+func fixedAbs(x int64) int64 {
+	if x == math.MinInt64 {
+		return math.MaxInt64
+	}
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// fixedSub returns a - b, saturating at the int64 range
+func fixedSub(a, b int64) int64 {
+	return saturate(new(big.Int).Sub(big.NewInt(a), big.NewInt(b)))
+}
+
+// fixedExpNeg computes e^x in Q16 for x <= 0. x is split into k*ln(2) + r
+// with r in (-ln(2), 0], e^r is summed as a Taylor series and the result is
+// shifted right by k.
+func fixedExpNeg(x int64) int64 {
+	if x > 0 {
+		x = 0
+	}
+	if x < -40*fixedOne {
+		return 0
+	}
+
+	k := -x / fixedLn2
+	r := x + k*fixedLn2
+
+	sum, term := fixedOne, fixedOne
+	for i := int64(1); i <= 8; i++ {
+		term = term * r / (i * fixedOne)
+		sum += term
+	}
+	return sum >> k
+}
+
+// fixedSigmoid computes 1 / (1 + e^-x) in Q16
+func fixedSigmoid(x int64) int64 {
+	if x >= 0 {
+		return fixedOne * fixedOne / (fixedOne + fixedExpNeg(-x))
+	}
+	e := fixedExpNeg(x)
+	return e * fixedOne / (fixedOne + e)
+}
+
+// quantize converts the feature statistics to Q16
+func (p *statsParams) quantize() fixedStats {
+	fs := fixedStats{
+		meanAmount:       toFixed(p.MeanAmount),
+		stdAmount:        toFixed(p.StdAmount),
+		maxAmount:        toFixed(p.MaxAmount),
+		senderCounts:     make(map[string]int64, len(p.SenderCounts)),
+		receiverCounts:   make(map[string]int64, len(p.ReceiverCounts)),
+		senderAverages:   make(map[string]int64, len(p.SenderAverages)),
+		receiverAverages: make(map[string]int64, len(p.ReceiverAverages)),
+	}
+	for addr, count := range p.SenderCounts {
+		fs.senderCounts[addr] = int64(count)
+	}
+	for addr, count := range p.ReceiverCounts {
+		fs.receiverCounts[addr] = int64(count)
+	}
+	for addr, average := range p.SenderAverages {
+		fs.senderAverages[addr] = toFixed(average)
+	}
+	for addr, average := range p.ReceiverAverages {
+		fs.receiverAverages[addr] = toFixed(average)
+	}
+	return fs
+}
+
+// quantize converts the trained float model to Q16
+func (p *modelParams) quantize() *fixedModel {
+	return &fixedModel{
+		fixedStats: p.statsParams.quantize(),
+		weights:    toFixedSlice(p.Weights),
+		bias:       toFixed(p.Bias),
+	}
+}
+
+// toFixedSlice converts each value to Q16
+func toFixedSlice(values []float64) []int64 {
+	fixed := make([]int64, len(values))
+	for i, value := range values {
+		fixed[i] = toFixed(value)
+	}
+	return fixed
+}
+
+// features computes the same five features as extractFeatures, in Q16
+func (fs *fixedStats) features(sender, receiver string, amount float64) []int64 {
+	a := toFixed(amount)
+	return []int64{
+		mulDiv(fixedSub(a, fs.meanAmount), fixedOne, fs.stdAmount),
+		mulDiv(fs.senderCounts[sender], fixedOne, int64(len(fs.senderCounts))),
+		mulDiv(fs.receiverCounts[receiver], fixedOne, int64(len(fs.receiverCounts))),
+		mulDiv(fixedAbs(fixedSub(a, fs.senderAverages[sender])), fixedOne, fs.maxAmount),
+		mulDiv(fixedAbs(fixedSub(a, fs.receiverAverages[receiver])), fixedOne, fs.maxAmount),
+	}
+}
+
+// fixedDot returns bias + sum(features[i] * weights[i]) in Q16, saturating
+// at the int64 range
+func fixedDot(features, weights []int64, bias int64) int64 {
+	sum := big.NewInt(bias)
+	for i, feature := range features {
+		term := new(big.Int).Mul(big.NewInt(feature), big.NewInt(weights[i]))
+		sum.Add(sum, term.Quo(term, big.NewInt(fixedOne)))
+	}
+	return saturate(sum)
+}
+
+// predict returns the probability that a transaction is valid, in Q16
+func (fm *fixedModel) predict(features []int64) int64 {
+	return fixedSigmoid(fixedDot(features, fm.weights, fm.bias))
+}
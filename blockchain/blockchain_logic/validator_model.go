@@ -0,0 +1,185 @@
+// validator_model.go
+package blockchain_logic
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ModelFormatVersion is the version of the serialized validator model format
+const ModelFormatVersion = 1
+
+// statsParams are the serialized feature statistics. They are embedded in
+// each model's parameters, so they encode as fields of the model.
+type statsParams struct {
+	MeanAmount       float64            `json:"mean_amount"`
+	StdAmount        float64            `json:"std_amount"`
+	MaxAmount        float64            `json:"max_amount"`
+	MinAmount        float64            `json:"min_amount"`
+	SenderCounts     map[string]int     `json:"sender_counts"`
+	ReceiverCounts   map[string]int     `json:"receiver_counts"`
+	SenderAverages   map[string]float64 `json:"sender_averages"`
+	ReceiverAverages map[string]float64 `json:"receiver_averages"`
+}
+
+// modelParams holds everything the logistic validator learned during training
+type modelParams struct {
+	Weights []float64 `json:"weights"`
+	Bias    float64   `json:"bias"`
+	statsParams
+}
+
+// modelFile is the serialized form of a trained validator. Files without a
+// model type hold a logistic model. The reference feature values only serve
+// drift monitoring and are not covered by the model hash.
+type modelFile struct {
+	FormatVersion int             `json:"format_version"`
+	ModelType     string          `json:"model_type,omitempty"`
+	ModelHash     string          `json:"model_hash"`
+	Model         json.RawMessage `json:"model"`
+	Reference     [][]float64     `json:"reference_features,omitempty"`
+}
+
+// hashParams returns the SHA-256 of the parameters' JSON encoding. Map keys
+// are encoded in sorted order, so equal models always hash the same.
+func hashParams(params interface{}) string {
+	data, _ := json.Marshal(params)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// writeModel writes trained parameters with their type and hash, along with
+// the reference feature values of the training data
+func writeModel(w io.Writer, modelType string, params interface{}, reference [][]float64) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to write model: %v", err)
+	}
+	if modelType == ValidatorLogistic {
+		modelType = ""
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(modelFile{
+		FormatVersion: ModelFormatVersion,
+		ModelType:     modelType,
+		ModelHash:     hashParams(params),
+		Model:         data,
+		Reference:     reference,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write model: %v", err)
+	}
+	return nil
+}
+
+// readModel reads parameters written by writeModel into params, after
+// checking the format version, model type and model hash, and returns the
+// reference feature values
+func readModel(r io.Reader, modelType string, params interface{}) ([][]float64, error) {
+	var file modelFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to read model: %v", err)
+	}
+	if file.FormatVersion != ModelFormatVersion {
+		return nil, fmt.Errorf("unsupported model format version %d", file.FormatVersion)
+	}
+	if file.ModelType == "" {
+		file.ModelType = ValidatorLogistic
+	}
+	if file.ModelType != modelType {
+		return nil, fmt.Errorf("model file holds a %s model, expected %s", file.ModelType, modelType)
+	}
+	if err := json.Unmarshal(file.Model, params); err != nil {
+		return nil, fmt.Errorf("failed to read model: %v", err)
+	}
+	if hash := hashParams(params); hash != file.ModelHash {
+		return nil, fmt.Errorf("model hash mismatch: file says %s, contents hash to %s", file.ModelHash, hash)
+	}
+	if file.Reference != nil && len(file.Reference) != numFeatures {
+		return nil, fmt.Errorf("model has reference values for %d features, expected %d", len(file.Reference), numFeatures)
+	}
+	return file.Reference, nil
+}
+
+// params returns the feature statistics for serialization
+func (fs *featureStats) params() statsParams {
+	return statsParams{
+		MeanAmount:       fs.meanAmount,
+		StdAmount:        fs.stdAmount,
+		MaxAmount:        fs.maxAmount,
+		MinAmount:        fs.minAmount,
+		SenderCounts:     fs.senderCounts,
+		ReceiverCounts:   fs.receiverCounts,
+		SenderAverages:   fs.senderAverages,
+		ReceiverAverages: fs.receiverAverages,
+	}
+}
+
+// load replaces the feature statistics with serialized ones
+func (fs *featureStats) load(p statsParams) {
+	fs.meanAmount = p.MeanAmount
+	fs.stdAmount = p.StdAmount
+	fs.maxAmount = p.MaxAmount
+	fs.minAmount = p.MinAmount
+	fs.senderCounts = nonNilMap(p.SenderCounts)
+	fs.receiverCounts = nonNilMap(p.ReceiverCounts)
+	fs.senderAverages = nonNilMap(p.SenderAverages)
+	fs.receiverAverages = nonNilMap(p.ReceiverAverages)
+	fs.count = 0
+	for _, count := range fs.senderCounts {
+		fs.count += count
+	}
+}
+
+// params returns the trained parameters of the validator
+func (mv *MLTransactionValidator) params() *modelParams {
+	return &modelParams{
+		Weights:     mv.weights,
+		Bias:        mv.bias,
+		statsParams: mv.featureStats.params(),
+	}
+}
+
+// ModelHash identifies the trained model, so nodes can check that they run
+// the same audited artifact
+func (mv *MLTransactionValidator) ModelHash() string {
+	return hashParams(mv.params())
+}
+
+// Save writes the trained model to w
+func (mv *MLTransactionValidator) Save(w io.Writer) error {
+	return writeModel(w, ValidatorLogistic, mv.params(), mv.reference)
+}
+
+// Load replaces the validator's model with one written by Save, after
+// checking its format version and model hash
+func (mv *MLTransactionValidator) Load(r io.Reader) error {
+	var params modelParams
+	reference, err := readModel(r, ValidatorLogistic, &params)
+	if err != nil {
+		return err
+	}
+	if len(params.Weights) != len(mv.weights) {
+		return fmt.Errorf("model has %d weights, expected %d", len(params.Weights), len(mv.weights))
+	}
+
+	mv.weights = params.Weights
+	mv.bias = params.Bias
+	mv.featureStats.load(params.statsParams)
+	mv.reference = reference
+	mv.fixed = mv.params().quantize()
+	return nil
+}
+
+// nonNilMap returns m, or an empty map if m is nil
+func nonNilMap[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return make(map[string]V)
+	}
+	return m
+}
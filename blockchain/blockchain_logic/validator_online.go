@@ -0,0 +1,69 @@
+// validator_online.go
+package blockchain_logic
+
+import "math"
+
+const (
+	// onlineLearningRate scales the gradient step taken for each block
+	onlineLearningRate = 0.01
+	// onlineMaxStep bounds how far one block can move any weight or the bias
+	onlineMaxStep = 0.05
+)
+
+// Learn takes one bounded gradient step on the transactions of a confirmed
+// block and then adds them to the feature statistics, so senders and
+// receivers seen on chain stop looking like strangers. Transactions are
+// labeled by their outcome: a confirmed transaction was accepted into a block,
+// so it is a valid example. Predictions come from the fixed-point model and
+// products are rounded explicitly with float64(), so every node learns
+// exactly the same model.
+func (mv *MLTransactionValidator) Learn(transactions []Transaction) {
+	if len(transactions) == 0 {
+		return
+	}
+
+	gradient := make([]float64, len(mv.weights))
+	var biasGradient float64
+	for _, tx := range transactions {
+		prediction := fromFixed(mv.fixed.predict(mv.fixed.features(tx.Sender, tx.Receiver, tx.Amount)))
+		loss := 1 - prediction
+
+		features := mv.extractFeatures(tx.Sender, tx.Receiver, tx.Amount)
+		for i, feature := range features {
+			gradient[i] += float64(loss * feature)
+		}
+		biasGradient += loss
+	}
+
+	n := float64(len(transactions))
+	for i := range mv.weights {
+		mv.weights[i] += boundedStep(gradient[i] / n)
+	}
+	mv.bias += boundedStep(biasGradient / n)
+
+	for _, tx := range transactions {
+		mv.featureStats.add(tx.Sender, tx.Receiver, tx.Amount)
+	}
+	mv.fixed = mv.params().quantize()
+}
+
+// boundedStep scales a gradient by the learning rate and clips it to
+// onlineMaxStep. Undefined gradients give no step.
+func boundedStep(gradient float64) float64 {
+	step := float64(onlineLearningRate * gradient)
+	if math.IsNaN(step) {
+		return 0
+	}
+	return math.Max(-onlineMaxStep, math.Min(onlineMaxStep, step))
+}
+
+// Clone returns an independent copy of the model
+func (mv *MLTransactionValidator) Clone() OnlineValidator {
+	return &MLTransactionValidator{
+		featureStats:    mv.featureStats.clone(),
+		weights:         append([]float64(nil), mv.weights...),
+		bias:            mv.bias,
+		ValidationSplit: mv.ValidationSplit,
+		fixed:           mv.fixed, // Never modified, only replaced
+	}
+}
@@ -0,0 +1,209 @@
+// velocity.go
+package blockchain_logic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultVelocityWindow is the number of recent blocks velocity features
+	// are computed over
+	DefaultVelocityWindow = 10
+	// DefaultMaxTxRate is the number of transactions per block a sender may
+	// make over the window before being flagged
+	DefaultMaxTxRate = 5
+	// DefaultMaxWindowAmount is the amount a sender may send over the window
+	// before being flagged
+	DefaultMaxWindowAmount = 5000
+	// DefaultMaxFanOut and DefaultMaxFanIn are the numbers of distinct
+	// receivers of a sender, and senders of a receiver, over the window before
+	// being flagged
+	DefaultMaxFanOut = 10
+	DefaultMaxFanIn  = 10
+	// DefaultMaxCycleLength is the number of transfers in the longest cycle
+	// searched for
+	DefaultMaxCycleLength = 4
+)
+
+// VelocityConfig holds the window and limits of the velocity checks. The
+// checks are advisory: they flag transactions for review and never reject
+// one, and the model does not use them as inputs.
+type VelocityConfig struct {
+	// Window is the number of recent blocks taken into account. Defaults to
+	// DefaultVelocityWindow.
+	Window          int
+	MaxTxRate       float64
+	MaxWindowAmount float64
+	MaxFanOut       int
+	MaxFanIn        int
+	MaxCycleLength  int
+}
+
+// withDefaults fills in unset settings
+func (vc VelocityConfig) withDefaults() VelocityConfig {
+	if vc.Window <= 0 {
+		vc.Window = DefaultVelocityWindow
+	}
+	if vc.MaxTxRate <= 0 {
+		vc.MaxTxRate = DefaultMaxTxRate
+	}
+	if vc.MaxWindowAmount <= 0 {
+		vc.MaxWindowAmount = DefaultMaxWindowAmount
+	}
+	if vc.MaxFanOut <= 0 {
+		vc.MaxFanOut = DefaultMaxFanOut
+	}
+	if vc.MaxFanIn <= 0 {
+		vc.MaxFanIn = DefaultMaxFanIn
+	}
+	if vc.MaxCycleLength < 2 {
+		vc.MaxCycleLength = DefaultMaxCycleLength
+	}
+	return vc
+}
+
+// VelocityFeatures describe a transaction in the context of the recent
+// transfers of its sender and receiver. Counts and amounts include the
+// transaction itself.
+type VelocityFeatures struct {
+	SenderTxRate       float64  `json:"sender_tx_rate"`       // Sender transactions per block
+	SenderWindowAmount float64  `json:"sender_window_amount"` // Total sent by the sender
+	NewInWindow        bool     `json:"new_in_window"`        // No transfer from the sender to the receiver within the window
+	SenderFanOut       int      `json:"sender_fan_out"`       // Distinct receivers of the sender
+	ReceiverFanIn      int      `json:"receiver_fan_in"`      // Distinct senders to the receiver
+	Cycle              []string `json:"cycle,omitempty"`      // Addresses of a transfer cycle the transaction closes, starting with the sender
+	Alerts             []string `json:"alerts,omitempty"`     // Limits the transaction exceeds
+}
+
+// transferGraph is the graph of transfers in the velocity window: the
+// transactions of recent main chain blocks and those accepted earlier in the
+// batch being validated. Other pending mempool transactions are not part of
+// it.
+type transferGraph struct {
+	config    VelocityConfig
+	blocks    int                       // Blocks the rate is averaged over
+	sent      map[string]int            // Transactions by sender
+	amounts   map[string]float64        // Amount sent by sender
+	receivers map[string]map[string]int // Transfers from a sender to each receiver
+	senders   map[string]map[string]bool
+}
+
+// newTransferGraph builds the transfer graph of the last config.Window
+// blocks of a chain. The genesis block only holds allocations and is left
+// out.
+func newTransferGraph(config VelocityConfig, chain []*Block) *transferGraph {
+	g := &transferGraph{
+		config:    config,
+		blocks:    1, // The block the validated transactions go into
+		sent:      make(map[string]int),
+		amounts:   make(map[string]float64),
+		receivers: make(map[string]map[string]int),
+		senders:   make(map[string]map[string]bool),
+	}
+	start := len(chain) - config.Window + 1
+	if start < 1 {
+		start = 1
+	}
+	for _, block := range chain[start:] {
+		g.blocks++
+		for _, tx := range block.Transactions {
+			g.add(tx)
+		}
+	}
+	return g
+}
+
+// add records a transfer
+func (g *transferGraph) add(tx Transaction) {
+	g.sent[tx.Sender]++
+	g.amounts[tx.Sender] += tx.Amount
+	if g.receivers[tx.Sender] == nil {
+		g.receivers[tx.Sender] = make(map[string]int)
+	}
+	g.receivers[tx.Sender][tx.Receiver]++
+	if g.senders[tx.Receiver] == nil {
+		g.senders[tx.Receiver] = make(map[string]bool)
+	}
+	g.senders[tx.Receiver][tx.Sender] = true
+}
+
+// features computes the velocity features of a transaction not yet in the
+// graph and checks them against the limits
+func (g *transferGraph) features(tx Transaction) *VelocityFeatures {
+	receivers := g.receivers[tx.Sender]
+	fanOut := len(receivers)
+	if receivers[tx.Receiver] == 0 {
+		fanOut++
+	}
+	fanIn := len(g.senders[tx.Receiver])
+	if !g.senders[tx.Receiver][tx.Sender] {
+		fanIn++
+	}
+
+	vf := &VelocityFeatures{
+		SenderTxRate:       float64(g.sent[tx.Sender]+1) / float64(g.blocks),
+		SenderWindowAmount: g.amounts[tx.Sender] + tx.Amount,
+		NewInWindow:        receivers[tx.Receiver] == 0,
+		SenderFanOut:       fanOut,
+		ReceiverFanIn:      fanIn,
+		Cycle:              g.findCycle(tx.Sender, tx.Receiver),
+	}
+	if vf.SenderTxRate > g.config.MaxTxRate {
+		vf.Alerts = append(vf.Alerts, fmt.Sprintf("sender rate %.2f transactions per block", vf.SenderTxRate))
+	}
+	if vf.SenderWindowAmount > g.config.MaxWindowAmount {
+		vf.Alerts = append(vf.Alerts, fmt.Sprintf("sender sent %.2f in %d blocks", vf.SenderWindowAmount, g.blocks))
+	}
+	if vf.SenderFanOut > g.config.MaxFanOut {
+		vf.Alerts = append(vf.Alerts, fmt.Sprintf("sender paid %d receivers", vf.SenderFanOut))
+	}
+	if vf.ReceiverFanIn > g.config.MaxFanIn {
+		vf.Alerts = append(vf.Alerts, fmt.Sprintf("receiver paid by %d senders", vf.ReceiverFanIn))
+	}
+	if vf.Cycle != nil {
+		vf.Alerts = append(vf.Alerts, "transfer cycle "+strings.Join(append(vf.Cycle, tx.Sender), " -> "))
+	}
+	return vf
+}
+
+// findCycle looks for a chain of transfers from receiver back to sender, so
+// that a transfer from sender to receiver closes a cycle of at most
+// MaxCycleLength transfers. It returns the addresses on the shortest such
+// cycle, starting with the sender, or nil.
+func (g *transferGraph) findCycle(sender, receiver string) []string {
+	if sender == receiver {
+		return nil
+	}
+	// Breadth-first search, visiting receivers in sorted order so every node
+	// reports the same cycle
+	previous := map[string]string{receiver: sender}
+	frontier := []string{receiver}
+	for depth := 1; depth < g.config.MaxCycleLength && len(frontier) > 0; depth++ {
+		var next []string
+		for _, from := range frontier {
+			targets := make([]string, 0, len(g.receivers[from]))
+			for to := range g.receivers[from] {
+				targets = append(targets, to)
+			}
+			sort.Strings(targets)
+			for _, to := range targets {
+				if _, seen := previous[to]; seen {
+					continue
+				}
+				previous[to] = from
+				if to == sender {
+					var cycle []string
+					for at := from; at != sender; at = previous[at] {
+						cycle = append([]string{at}, cycle...)
+					}
+					return append([]string{sender}, cycle...)
+				}
+				next = append(next, to)
+			}
+		}
+		frontier = next
+	}
+	return nil
+}
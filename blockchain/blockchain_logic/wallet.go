@@ -0,0 +1,148 @@
+// wallet.go
+package blockchain_logic
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// addressLength is the number of public key hash bytes used in an address
+const addressLength = 20
+
+// Wallet is an Ed25519 keypair together with the address derived from it
+type Wallet struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+	Address    string
+}
+
+// NewWallet generates a wallet with a random keypair
+func NewWallet() (*Wallet, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate keypair: %v", err)
+	}
+	return newWallet(privateKey, publicKey), nil
+}
+
+// NewWalletFromSeed derives a wallet deterministically from a seed
+func NewWalletFromSeed(seed []byte) *Wallet {
+	seedHash := sha256.Sum256(seed)
+	privateKey := ed25519.NewKeyFromSeed(seedHash[:])
+	return newWallet(privateKey, privateKey.Public().(ed25519.PublicKey))
+}
+
+// DevWallet derives the wallet for a named account of the CSV simulation
+// data. Anyone who knows the name can derive the key, so dev wallets must
+// only be used for test and demo data.
+func DevWallet(name string) *Wallet {
+	return NewWalletFromSeed([]byte("dev-wallet:" + name))
+}
+
+// DevAddress returns the dev wallet address of a named account of the CSV
+// data. Names that already are addresses are returned unchanged, so data
+// may name accounts either way.
+func DevAddress(name string) string {
+	if decoded, err := hex.DecodeString(name); err == nil && len(decoded) == addressLength {
+		return name
+	}
+	return DevWallet(name).Address
+}
+
+func newWallet(privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) *Wallet {
+	return &Wallet{
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+		Address:    AddressFromPublicKey(publicKey),
+	}
+}
+
+// AddressFromPublicKey derives an address from the first 20 bytes of the
+// SHA-256 hash of the public key
+func AddressFromPublicKey(publicKey ed25519.PublicKey) string {
+	hash := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hash[:addressLength])
+}
+
+// SignTransaction signs the transaction, setting its sender to the wallet's
+// address and attaching the public key and signature
+func (w *Wallet) SignTransaction(tx *Transaction) {
+	tx.Sender = w.Address
+	tx.PublicKey = hex.EncodeToString(w.PublicKey)
+	tx.Signature = hex.EncodeToString(ed25519.Sign(w.PrivateKey, tx.SigningBytes()))
+}
+
+// SigningBytes returns the canonical encoding of the signed transaction
+// fields. The fields are marshalled in a fixed order so every node derives
+// the same bytes.
+func (tx *Transaction) SigningBytes() []byte {
+	data, _ := json.Marshal(struct {
+		Sender    string  `json:"sender"`
+		Receiver  string  `json:"receiver"`
+		Amount    float64 `json:"amount"`
+		Timestamp int64   `json:"timestamp"`
+		Nonce     uint64  `json:"nonce"`
+	}{
+		Sender:    tx.Sender,
+		Receiver:  tx.Receiver,
+		Amount:    tx.Amount,
+		Timestamp: tx.Timestamp,
+		Nonce:     tx.Nonce,
+	})
+	return data
+}
+
+// VerifySignature checks that the transaction is signed by the key its
+// sender address is derived from
+func (tx *Transaction) VerifySignature() error {
+	if tx.PublicKey == "" || tx.Signature == "" {
+		return fmt.Errorf("transaction is not signed")
+	}
+
+	publicKey, err := hex.DecodeString(tx.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key")
+	}
+	signature, err := hex.DecodeString(tx.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	if AddressFromPublicKey(publicKey) != tx.Sender {
+		return fmt.Errorf("public key does not match sender address %s", tx.Sender)
+	}
+	if !ed25519.Verify(publicKey, tx.SigningBytes(), signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+// SignWithDevWallets converts named CSV transactions into signed
+// transactions between the corresponding dev wallet addresses. Each sender's
+// transactions get consecutive nonces starting at zero, in file order.
+func SignWithDevWallets(transactions []Transaction) []Transaction {
+	signed := make([]Transaction, 0, len(transactions))
+	nonces := make(map[string]uint64)
+	for _, tx := range transactions {
+		tx.Receiver = DevAddress(tx.Receiver)
+		tx.Nonce = nonces[tx.Sender]
+		nonces[tx.Sender]++
+		DevWallet(tx.Sender).SignTransaction(&tx)
+		signed = append(signed, tx)
+	}
+	return signed
+}
+
+// DevGenesisAlloc gives every sender of the signed transactions the same
+// starting balance
+func DevGenesisAlloc(transactions []Transaction, balance float64) map[string]float64 {
+	alloc := make(map[string]float64)
+	for _, tx := range transactions {
+		alloc[tx.Sender] = balance
+	}
+	return alloc
+}
@@ -3,6 +3,9 @@ package main
 
 import (
 	"blockchain/blockchain_logic"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -12,7 +15,25 @@ import (
 
 const BACKUP_INTERVAL = 5 * time.Minute
 
+// DEV_GENESIS_BALANCE is the starting balance of every simulated sender
+const DEV_GENESIS_BALANCE = 10000
+
+// MAX_BLOCK_TRANSACTIONS caps the number of transactions mined per block
+const MAX_BLOCK_TRANSACTIONS = 20
+
 func main() {
+	storeBackend := flag.String("store", blockchain_logic.BlockStoreIPFS, "block store backend: ipfs, fs or memory")
+	storeLocation := flag.String("store-path", "", "IPFS API address or data directory of the block store")
+	dataDir := flag.String("datadir", "chaindata", "directory of the local chain database, empty to keep the chain in memory")
+	modelFile := flag.String("model", "", "saved ML model to load instead of training on transactions.csv")
+	saveModel := flag.String("save-model", "", "file to save the ML model to after startup")
+	validatorKind := flag.String("validator", "", "transaction validator: logistic, mlp or isolation-forest (default logistic, or the kind of the -model file)")
+	forestSeed := flag.Int64("forest-seed", blockchain_logic.DefaultForestSeed, "seed of the isolation forest; peers must use the same seed to agree on the model")
+	driftWindow := flag.Int("drift-window", 0, "compare the last n transactions with the training data and report feature drift, 0 to disable")
+	velocityWindow := flag.Int("velocity-window", 0, "flag transactions whose velocity over the last n blocks and the mempool exceeds the limits or that close a transfer cycle, 0 to disable")
+	modelPromotion := flag.Int64("model-promotion", 0, "learn from confirmed blocks and promote the learned validator model every n blocks, 0 to disable")
+	flag.Parse()
+
 	// Configure peer addresses
 	myAddress := "localhost:9001"
 	peerAddresses := []string{
@@ -26,50 +47,111 @@ func main() {
 	// Initialize the peer network
 	network := blockchain_logic.NewPeerNetwork(myAddress)
 
+	// Read transactions from CSV
+	transactionsPath := "../transactions.csv"
+	transactions, err := blockchain_logic.ReadTransactionsFromCSV(transactionsPath)
+	if err != nil {
+		fmt.Printf("Error reading transactions: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Successfully loaded %d transactions\n", len(transactions))
+	blockchain_logic.PrintTransactions(transactions)
+
+	// Sign the simulation transactions with the dev wallets of their senders
+	transactions = blockchain_logic.SignWithDevWallets(transactions)
+
+	// Initialize the block store backend
+	store, err := blockchain_logic.NewBlockStore(*storeBackend, *storeLocation)
+	if err != nil {
+		fmt.Printf("Error initializing block store: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Select the transaction validator model
+	var validator blockchain_logic.TransactionValidator
+	if *validatorKind != "" {
+		validator, err = blockchain_logic.NewTransactionValidator(*validatorKind)
+		if err != nil {
+			fmt.Printf("Error selecting validator: %v\n", err)
+			os.Exit(1)
+		}
+		if forest, ok := validator.(*blockchain_logic.IsolationForestValidator); ok {
+			forest.Seed = *forestSeed
+		}
+	}
+
+	var velocity *blockchain_logic.VelocityConfig
+	if *velocityWindow > 0 {
+		velocity = &blockchain_logic.VelocityConfig{Window: *velocityWindow}
+	}
+
 	// Initialize the blockchain with ML validator and training file
-	blockchain, err := blockchain_logic.NewBlockchain(4, "../transactions.csv")
+	blockchain, err := blockchain_logic.NewBlockchain(blockchain_logic.BlockchainConfig{
+		Difficulty:             blockchain_logic.DefaultDifficulty,
+		TargetBlockTime:        10 * time.Second,
+		TrainingFile:           "../transactions.csv",
+		Store:                  store,
+		GenesisAlloc:           blockchain_logic.DevGenesisAlloc(transactions, DEV_GENESIS_BALANCE),
+		DataDir:                *dataDir,
+		Validator:              validator,
+		ModelFile:              *modelFile,
+		ModelPromotionInterval: *modelPromotion,
+		DriftWindow:            *driftWindow,
+		Velocity:               velocity,
+	})
 	if err != nil {
 		fmt.Printf("Error initializing blockchain with ML validator: %v\n", err)
 		os.Exit(1)
 	}
+	if *saveModel != "" {
+		if err := blockchain_logic.SaveModelFile(blockchain.MLValidator, *saveModel); err != nil {
+			fmt.Printf("Error saving ML model: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("ML model %s saved to %s\n", blockchain.MLValidator.ModelHash(), *saveModel)
+	}
 	network.SetBlockchain(blockchain)
 
+	// Queue the simulation transactions in the mempool
+	for _, tx := range transactions {
+		if err := blockchain.Mempool.Add(tx); err != nil {
+			fmt.Printf("Error adding transaction to mempool: %v\n", err)
+		}
+	}
+
 	// Start the server first
 	go network.StartServer()
 
 	// Wait for the server to start
 	time.Sleep(2 * time.Second)
 
-	// Read transactions from CSV
-	transactionsPath := "../transactions.csv"
-	transactions, err := blockchain_logic.ReadTransactionsFromCSV(transactionsPath)
-	if err != nil {
-		fmt.Printf("Error reading transactions: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Printf("Successfully loaded %d transactions\n", len(transactions))
-	blockchain_logic.PrintTransactions(transactions)
-
 	// Connect to other peers with retry
 	fmt.Println("Connecting to peers...")
 	network.ConnectToPeersWithRetry(peerAddresses, 10)
 
+	// Mine on all CPUs until shutdown
+	miningCtx, stopMining := context.WithCancel(context.Background())
+	miner := blockchain_logic.NewMiner(0)
+
 	// Start mining process in a separate goroutine
 	go func() {
 		for {
-			// Validate transactions before creating block
-			validatedTransactions := blockchain.ValidateTransactionsML(transactions)
+			// Validate pending transactions before creating block
+			pendingTransactions := blockchain.Mempool.Pending(MAX_BLOCK_TRANSACTIONS)
+			validatedTransactions := blockchain.ValidateTransactionsML(pendingTransactions)
 
 			if len(validatedTransactions) > 0 {
-				// Create a new block with validated transactions
-				latestBlock := blockchain.GetLatestBlock()
-				newBlock := blockchain_logic.CreateBlock(
-					latestBlock.Index+1,
-					validatedTransactions,
-					latestBlock.Hash,
-					blockchain.Difficulty,
-				)
+				// Mine a new block on the current tip, starting over if
+				// another block arrives first
+				newBlock, err := miner.MineNext(miningCtx, blockchain, validatedTransactions)
+				if errors.Is(err, blockchain_logic.ErrStaleTip) {
+					fmt.Println("Chain tip changed, restarting mining")
+					continue
+				}
+				if err != nil {
+					return
+				}
 
 				// Try to add the block to the blockchain
 				if err := blockchain.AddBlock(newBlock); err != nil {
@@ -96,17 +178,12 @@ func main() {
 			time.Sleep(BACKUP_INTERVAL)
 			hash, err := blockchain.BackupToIPFS()
 			if err != nil {
-				fmt.Printf("Error backing up blockchain to IPFS: %v\n", err)
+				fmt.Printf("Error backing up blockchain: %v\n", err)
 				continue
 			}
 
 			// Broadcast the backup hash to peers
-			message := blockchain_logic.BlockchainMessage{
-				Type:    "IPFS_BACKUP",
-				Content: hash,
-				From:    myAddress,
-			}
-			network.BroadcastMessage(string(message.Type), message)
+			network.BroadcastIPFSBackup(hash)
 		}
 	}()
 
@@ -127,5 +204,7 @@ func main() {
 	fmt.Println("Press Ctrl+C to shutdown")
 
 	<-sigChan
+	stopMining()
+	blockchain.Close()
 	fmt.Println("\nShutting down peer 1...")
 }
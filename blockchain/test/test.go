@@ -8,7 +8,7 @@ import (
 func main() {
 	// Initialize and train the validator
 	validator := blockchain_logic.NewMLTransactionValidator()
-	err := validator.Train("transactions.csv")
+	_, err := validator.Train("transactions.csv")
 	if err != nil {
 		fmt.Printf("Error training model: %v\n", err)
 		return
@@ -27,10 +27,15 @@ func main() {
 	fmt.Println("----------------------")
 
 	for _, tx := range testTransactions {
-		valid, confidence, reason := validator.ValidateTransaction(tx)
 		fmt.Printf("\nTransaction: %s -> %s (%.2f)\n", tx.Sender, tx.Receiver, tx.Amount)
-		fmt.Printf("Valid: %v\n", valid)
-		fmt.Printf("Confidence: %.2f%%\n", confidence*100)
-		fmt.Printf("Reason: %s\n", reason)
+
+		// The model knows accounts by their dev wallet addresses
+		tx.Sender = blockchain_logic.DevAddress(tx.Sender)
+		tx.Receiver = blockchain_logic.DevAddress(tx.Receiver)
+		result := validator.Validate(tx)
+		fmt.Printf("Valid: %v\n", result.Valid)
+		fmt.Printf("Confidence: %.2f%%\n", result.Probability*100)
+		fmt.Printf("Reason: %s\n", result.Reason)
+		fmt.Println(validator.Explain(tx))
 	}
 }